@@ -0,0 +1,225 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// openFolder returns the filesystem to scan for -folder: the directory itself,
+// or the members of a .zip, .tar, .tar.gz or .tgz archive. Archives stay open
+// for the rest of the run, as uploads read from them after the scan. With no
+// folder, as when only -files are synced, it is empty.
+func openFolder(folder string) (fs.FS, error) {
+	if folder == "" {
+		return newArchiveFS(nil), nil
+	}
+	stat, err := os.Stat(folder)
+	if err != nil {
+		return nil, err
+	}
+	if stat.IsDir() {
+		return os.DirFS(folder), nil
+	}
+
+	name := strings.ToLower(folder)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		archive, err := zip.OpenReader(folder)
+		if err != nil {
+			return nil, err
+		}
+		return archive, nil
+	case strings.HasSuffix(name, ".tar"), strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return loadTar(folder)
+	default:
+		return nil, fmt.Errorf("not a directory or a supported archive")
+	}
+}
+
+// loadTar spools the regular files of a tar archive, gzip-compressed or not,
+// to a temporary file, as tar offers no random access to its members. They
+// are then read from their section of it, so none is held in memory.
+func loadTar(archivePath string) (fs.FS, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if !strings.HasSuffix(strings.ToLower(archivePath), ".tar") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	// The spool is only read through its handle, so it is removed right away where the
+	// platform allows it; elsewhere, as on Windows, it is left to the temp directory
+	spool, err := os.CreateTemp("", "openai-files-*.tar")
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(spool.Name())
+
+	archive := newArchiveFS(spool)
+	var offset int64
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return archive, nil
+		}
+		if err != nil {
+			spool.Close()
+			return nil, err
+		}
+
+		// Directories are implied by their members; links and devices have no content to upload
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := path.Clean(strings.TrimPrefix(header.Name, "/"))
+		if !fs.ValidPath(name) || name == "." {
+			continue
+		}
+		n, err := io.Copy(spool, tr)
+		if err != nil {
+			spool.Close()
+			return nil, err
+		}
+		archive.add(name, offset, n, header.FileInfo().Mode().Perm(), header.ModTime)
+		offset += n
+	}
+}
+
+// archiveFS serves archive members stored at offsets in data, along with the
+// directories they imply.
+type archiveFS struct {
+	data    io.ReaderAt
+	members map[string]*archiveMember
+}
+
+func newArchiveFS(data io.ReaderAt) *archiveFS {
+	root := &archiveMember{name: ".", mode: fs.ModeDir | 0755}
+	return &archiveFS{data: data, members: map[string]*archiveMember{".": root}}
+}
+
+// add records a file, creating its parent directories as needed. A later
+// member of the same name replaces the earlier one, as tar does.
+func (a *archiveFS) add(name string, offset, size int64, mode fs.FileMode, modTime time.Time) {
+	if m, ok := a.members[name]; ok {
+		if !m.IsDir() {
+			m.offset, m.size, m.mode, m.modTime = offset, size, mode, modTime
+		}
+		return
+	}
+	dir := a.dir(path.Dir(name))
+	if dir == nil {
+		return
+	}
+	m := &archiveMember{name: name, offset: offset, size: size, mode: mode, modTime: modTime}
+	a.members[name] = m
+	dir.insert(m)
+}
+
+// dir returns the directory member for name, creating it and its parents,
+// or nil when a file already has the name.
+func (a *archiveFS) dir(name string) *archiveMember {
+	if m, ok := a.members[name]; ok {
+		if !m.IsDir() {
+			return nil
+		}
+		return m
+	}
+	parent := a.dir(path.Dir(name))
+	if parent == nil {
+		return nil
+	}
+	m := &archiveMember{name: name, mode: fs.ModeDir | 0755}
+	a.members[name] = m
+	parent.insert(m)
+	return m
+}
+
+func (a *archiveFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	m, ok := a.members[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if m.IsDir() {
+		return &archiveDir{member: m}, nil
+	}
+	return &archiveFile{SectionReader: io.NewSectionReader(a.data, m.offset, m.size), member: m}, nil
+}
+
+// archiveMember is a file or directory in an archiveFS, and its FileInfo.
+type archiveMember struct {
+	name    string
+	offset  int64
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+	entries []fs.DirEntry // of a directory, sorted by name
+}
+
+func (m *archiveMember) Name() string       { return path.Base(m.name) }
+func (m *archiveMember) Size() int64        { return m.size }
+func (m *archiveMember) Mode() fs.FileMode  { return m.mode }
+func (m *archiveMember) ModTime() time.Time { return m.modTime }
+func (m *archiveMember) IsDir() bool        { return m.mode.IsDir() }
+func (m *archiveMember) Sys() any           { return nil }
+
+func (m *archiveMember) insert(child *archiveMember) {
+	entry := fs.FileInfoToDirEntry(child)
+	i, _ := slices.BinarySearchFunc(m.entries, entry.Name(), func(e fs.DirEntry, name string) int {
+		return strings.Compare(e.Name(), name)
+	})
+	m.entries = slices.Insert(m.entries, i, entry)
+}
+
+type archiveFile struct {
+	*io.SectionReader
+	member *archiveMember
+}
+
+func (f *archiveFile) Stat() (fs.FileInfo, error) { return f.member, nil }
+func (f *archiveFile) Close() error               { return nil }
+
+type archiveDir struct {
+	member *archiveMember
+	read   int
+}
+
+func (d *archiveDir) Stat() (fs.FileInfo, error) { return d.member, nil }
+func (d *archiveDir) Close() error               { return nil }
+
+func (d *archiveDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.member.name, Err: fs.ErrInvalid}
+}
+
+func (d *archiveDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	entries := d.member.entries[d.read:]
+	if n > 0 {
+		if len(entries) == 0 {
+			return nil, io.EOF
+		}
+		entries = entries[:min(n, len(entries))]
+	}
+	d.read += len(entries)
+	return slices.Clone(entries), nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// updateChangedAttributes applies the attributes of entries whose content is
+// unchanged but whose attributes changed, updating each vector store file in
+// place instead of uploading the file again. The API updates one file per
+// request, so the updates share the worker pool. It returns how many were
+// applied and, with -continue-on-error, the files that failed, which stay
+// pending for the next run. Without it, the first failure is returned as a
+// *SyncAbortedError.
+func updateChangedAttributes(manifest Manifest) (int, []SkippedFile, error) {
+	var pending []int
+	for i, fileInfo := range manifest.Files {
+		if fileInfo.AttributesPending && fileInfo.FileID != "" && fileInfo.Status != "failed" {
+			pending = append(pending, i)
+		}
+	}
+
+	var mu sync.Mutex
+	var failed []SkippedFile
+	var abort error
+	updated := 0
+	runPool(pending, func(i int) {
+		mu.Lock()
+		if abort != nil {
+			mu.Unlock()
+			return
+		}
+		fileInfo := manifest.Files[i]
+		mu.Unlock()
+
+		store := storeFor(fileInfo)
+		if store != "" {
+			if err := updateVectorStoreFileAttributes(store, fileInfo.FileID, fileInfo.Attributes); err != nil {
+				if continueOnError {
+					fmt.Printf("Error updating attributes of %s in vector store %s: %v\n", fileInfo.Path, store, err)
+				}
+				stats.failures.Add(1)
+				mu.Lock()
+				failed = append(failed, SkippedFile{Path: fileInfo.Path, Reason: err.Error()})
+				if !continueOnError && abort == nil {
+					abort = &SyncAbortedError{Path: fileInfo.Path, Err: fmt.Errorf("updating attributes in vector store %s: %w", store, err)}
+				}
+				mu.Unlock()
+				return
+			}
+			events.emit(Event{Type: "attributes_updated", Path: fileInfo.Path, FileID: fileInfo.FileID, VectorStoreID: store})
+		}
+
+		mu.Lock()
+		manifest.Files[i].AttributesPending = false
+		updated++
+		mu.Unlock()
+	})
+	if updated > 0 {
+		fmt.Printf("Updated attributes of %d files\n", updated)
+	}
+	return updated, failed, abort
+}
+
+func updateVectorStoreFileAttributes(storeID, fileID string, attributes map[string]interface{}) error {
+	if attributes == nil {
+		attributes = map[string]interface{}{}
+	}
+	valuesJSON, _ := json.Marshal(map[string]interface{}{"attributes": attributes})
+	url := fmt.Sprintf("https://api.openai.com/v1/vector_stores/%s/files/%s", storeID, fileID)
+
+	req, _ := http.NewRequest("POST", url, bytes.NewReader(valuesJSON))
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := doRequest(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := readBody(resp)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Non-OK HTTP status: %s: %s", resp.Status, string(respBody))
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+)
+
+// maxReportedLines bounds how many malformed lines are listed per file.
+const maxReportedLines = 10
+
+// runBatchInput uploads the folder's .jsonl files with purpose batch for the
+// Batch API, recording their file IDs in the -batch-manifest rather than the
+// sync manifest. Files already uploaded with the same content are kept, and
+// malformed files are reported and skipped.
+func runBatchInput(fsys fs.FS, root string) {
+	contentFS, contentRoot = fsys, root
+
+	previous, _ := loadManifest(batchManifestPath)
+	uploaded := make(map[string]FileInfo)
+	for _, fileInfo := range previous.Files {
+		uploaded[fileInfo.Path] = fileInfo
+	}
+
+	batch := Manifest{ManifestID: previous.ManifestID}
+	if batch.ManifestID == "" {
+		batch.ManifestID = generateManifestID(fsys, root)
+	}
+	invalid := 0
+	fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err == nil && ignoredDir(name, d) {
+			return fs.SkipDir
+		}
+		if err != nil || d.IsDir() || !strings.EqualFold(path.Ext(name), ".jsonl") {
+			return nil
+		}
+		filePath := manifestPath(name)
+
+		if problems := validateJSONL(filePath); len(problems) > 0 {
+			fmt.Printf("Skipping malformed batch input %s:\n", filePath)
+			for _, problem := range problems {
+				fmt.Printf("  %s\n", problem)
+			}
+			invalid++
+			return nil
+		}
+
+		hash, err := hashFile(filePath, hashAlgo)
+		if err != nil {
+			fmt.Printf("Skipping unreadable batch input %s: %v\n", filePath, err)
+			invalid++
+			return nil
+		}
+		if prev, ok := uploaded[filePath]; ok && prev.FileID != "" && prev.hashAlgo() == hashAlgo && prev.SHA256 == hash {
+			batch.Files = append(batch.Files, prev)
+			return nil
+		}
+
+		fileInfo := FileInfo{Path: filePath, SHA256: hash, HashAlgo: hashAlgo, Purpose: "batch"}
+		if !dryRun {
+			fileID, err := uploadFile(filePath, fileInfo.uploadName(), batch.ManifestID, "batch", "", nil)
+			if err != nil {
+				fmt.Printf("Error uploading %s: %v\n", filePath, err)
+				fileInfo.Status, fileInfo.Error = "failed", err.Error()
+			} else {
+				fmt.Printf("Uploaded %s, got FileID: %s\n", filePath, fileID)
+				fileInfo.FileID = fileID
+			}
+		}
+		batch.Files = append(batch.Files, fileInfo)
+		return nil
+	})
+
+	batch.LoggingInfo = LogInfo{GeneratedAt: time.Now().Format(time.RFC3339), OpenAIAPIKey: hideAPIKey(apiKey), ScanFolder: root, DryRun: dryRun, OutputFile: batchManifestPath}
+	if !dryRunHTTP {
+		saveOrPrintManifest(batch, batchManifestPath)
+	}
+	if invalid > 0 {
+		fmt.Printf("%d malformed or unreadable batch input files skipped\n", invalid)
+		exit(1)
+	}
+}
+
+// validateJSONL checks that every line of a file is a JSON object, as the
+// Batch API requires, and describes the first malformed lines.
+func validateJSONL(path string) []string {
+	file, err := openPath(path)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	defer file.Close()
+
+	var problems []string
+	r := bufio.NewReader(file)
+	for lineNo := 1; ; lineNo++ {
+		line, err := r.ReadBytes('\n')
+		if len(line) == 0 && err == io.EOF {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return append(problems, err.Error())
+		}
+
+		line = bytes.TrimSpace(line)
+		switch {
+		case len(line) == 0:
+			problems = append(problems, fmt.Sprintf("line %d: empty line", lineNo))
+		case line[0] != '{' || !json.Valid(line):
+			problems = append(problems, fmt.Sprintf("line %d: not a JSON object", lineNo))
+		}
+		if len(problems) == maxReportedLines || err == io.EOF {
+			break
+		}
+	}
+	return problems
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+type ChangeReport struct {
+	New     []string `json:"new"`
+	Changed []string `json:"changed"`
+	Removed []string `json:"removed"`
+}
+
+// reportChanges prints the files that differ between the previous manifest
+// and a fresh scan of the folder, without uploading or deleting anything.
+func reportChanges(scanned, previous Manifest) {
+	tracked := make(map[string]FileInfo)
+	for _, fileInfo := range previous.Files {
+		tracked[fileInfo.Path] = fileInfo
+	}
+
+	report := ChangeReport{New: []string{}, Changed: []string{}, Removed: []string{}}
+	seen := make(map[string]bool)
+	for _, fileInfo := range scanned.Files {
+		seen[fileInfo.Path] = true
+		// scanFolder keeps unchanged entries as they were, apart from rehashing
+		// them after a -hash-algo switch, and replaces changed ones
+		prev, exists := tracked[fileInfo.Path]
+		if prev.hashAlgo() != fileInfo.hashAlgo() {
+			prev.SHA256, prev.HashAlgo = fileInfo.SHA256, fileInfo.HashAlgo
+		}
+		switch {
+		case !exists:
+			report.New = append(report.New, fileInfo.Path)
+		case !reflect.DeepEqual(prev, fileInfo):
+			report.Changed = append(report.Changed, fileInfo.Path)
+		}
+	}
+	for path := range tracked {
+		if !seen[path] {
+			report.Removed = append(report.Removed, path)
+		}
+	}
+	sort.Strings(report.New)
+	sort.Strings(report.Changed)
+	sort.Strings(report.Removed)
+
+	if reportFormat == "json" {
+		data, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(data))
+		return
+	}
+
+	for _, path := range report.New {
+		fmt.Printf("new %s\n", path)
+	}
+	for _, path := range report.Changed {
+		fmt.Printf("changed %s\n", path)
+	}
+	for _, path := range report.Removed {
+		fmt.Printf("removed %s\n", path)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// OutOfDate is a change -check found a sync would make.
+type OutOfDate struct {
+	Path   string `json:"path"`
+	Change string `json:"change"` // upload, attributes, reindex or delete
+}
+
+// reportOutOfDate prints what a sync would change after a dry run, as
+// -check does, and returns how many changes there are.
+func reportOutOfDate(manifest Manifest) int {
+	changes := []OutOfDate{}
+	for _, fileInfo := range manifest.Files {
+		switch {
+		case fileInfo.FileID == "":
+			changes = append(changes, OutOfDate{Path: fileInfo.Path, Change: "upload"})
+		case fileInfo.Status == "failed":
+			changes = append(changes, OutOfDate{Path: fileInfo.Path, Change: "reindex"})
+		case fileInfo.AttributesPending:
+			changes = append(changes, OutOfDate{Path: fileInfo.Path, Change: "attributes"})
+		}
+	}
+	if cleanup {
+		for _, fileInfo := range manifest.PendingDeletes {
+			changes = append(changes, OutOfDate{Path: fileInfo.Path, Change: "delete"})
+		}
+	}
+
+	if reportFormat == "json" {
+		data, _ := json.MarshalIndent(changes, "", "  ")
+		fmt.Println(string(data))
+		return len(changes)
+	}
+
+	for _, change := range changes {
+		fmt.Printf("%s %s\n", change.Change, change.Path)
+	}
+	if len(changes) == 0 {
+		fmt.Println("In sync")
+	} else {
+		fmt.Printf("%d changes pending\n", len(changes))
+	}
+	return len(changes)
+}
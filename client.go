@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// httpClient is shared by every OpenAI API call so transport settings and
+// instrumentation apply uniformly.
+var httpClient = &http.Client{}
+
+// configureTransport sets up the shared client's connections: the TLS flags,
+// and -concurrency-per-host, which caps connections to the API separately
+// from the number of workers. Workers beyond the cap wait for a free
+// connection. Idle connections are kept for every worker so they are reused.
+func configureTransport() error {
+	tlsConfig, err := loadTLSConfig()
+	if err != nil {
+		return err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	transport.MaxConnsPerHost = concurrencyPerHost
+	transport.MaxIdleConnsPerHost = max(concurrency, concurrencyPerHost, http.DefaultMaxIdleConnsPerHost)
+	httpClient.Transport = transport
+	return nil
+}
+
+// doRequest sends req with the shared client, logging its duration, size and
+// status at debug level.
+func doRequest(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	if simulateLatency > 0 && !dryRun {
+		time.Sleep(simulateLatency)
+	}
+	if traceHTTP {
+		req = withTrace(req)
+	}
+	resp, err := httpClient.Do(req)
+
+	attrs := []any{
+		"method", req.Method,
+		"url", req.URL.String(),
+		"bytes", req.ContentLength,
+		"duration", time.Since(start),
+	}
+	if err != nil {
+		slog.Debug("request failed", append(attrs, "error", err)...)
+		if t := throttle; t != nil {
+			t.record(true)
+		}
+		return nil, err
+	}
+	slog.Debug("request completed", append(attrs, "status", resp.StatusCode)...)
+	if l := limiter; l != nil && resp.StatusCode == http.StatusTooManyRequests {
+		l.backoff()
+	}
+	if t := throttle; t != nil {
+		t.record(resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests)
+	}
+	return resp, nil
+}
+
+// dryRunTransport logs a description of each request instead of sending it,
+// answering with a synthetic success so the run can continue.
+type dryRunTransport struct {
+	requests atomic.Int64
+}
+
+func (t *dryRunTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	n := t.requests.Add(1)
+
+	headers := make(map[string]string)
+	for name := range req.Header {
+		value := req.Header.Get(name)
+		if name == "Authorization" {
+			value = "Bearer " + hideAPIKey(strings.TrimPrefix(value, "Bearer "))
+		}
+		headers[name] = value
+	}
+
+	id := fmt.Sprintf("dry-run-%d", n)
+	body := fmt.Sprintf("%d bytes", req.ContentLength)
+	if req.Body != nil && strings.HasPrefix(req.Header.Get("Content-Type"), "application/json") {
+		data, _ := ioutil.ReadAll(io.LimitReader(req.Body, 512))
+		body = string(data)
+
+		// Objects created for a file, such as vector store files, are identified by it
+		var values struct {
+			FileID string `json:"file_id"`
+		}
+		if json.Unmarshal(data, &values) == nil && values.FileID != "" {
+			id = values.FileID
+		}
+	}
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	slog.Info("dry-run request", "method", req.Method, "url", req.URL.String(), "headers", headers, "body", body)
+
+	// Carries both a top-level id and a nested file id, satisfying every endpoint's parser
+	respBody := fmt.Sprintf(`{"id":%q,"status":"completed","file":{"id":"dry-run-file-%d"}}`, id, n)
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"application/json"}},
+		Body:          ioutil.NopCloser(strings.NewReader(respBody)),
+		ContentLength: int64(len(respBody)),
+		Request:       req,
+	}, nil
+}
+
+// readBody reads a response body of at most -max-response-bytes, guarding
+// against a broken or hostile proxy returning an unbounded body. When the
+// limit is exceeded the truncated body is returned along with an error.
+func readBody(resp *http.Response) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseBytes+1))
+	if err != nil {
+		return data, err
+	}
+	if int64(len(data)) > maxResponseBytes {
+		return data[:maxResponseBytes], fmt.Errorf("response body from %s exceeds %d bytes", resp.Request.URL, maxResponseBytes)
+	}
+	return data, nil
+}
+
+func setupLogger(level, format string) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid -log-level: %s", level)
+	}
+
+	w, err := logOutput()
+	if err != nil {
+		return fmt.Errorf("opening -log-file: %w", err)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch format {
+	case "text":
+		slog.SetDefault(slog.New(slog.NewTextHandler(w, opts)))
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(w, opts)))
+	default:
+		return fmt.Errorf("invalid -log-format: %s", format)
+	}
+	return nil
+}
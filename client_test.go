@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// serveAPI answers the requests meant for the OpenAI API with handler for
+// the rest of the test.
+func serveAPI(t *testing.T, handler http.HandlerFunc) {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	target, _ := url.Parse(server.URL)
+
+	saved := httpClient.Transport
+	httpClient.Transport = redirectTransport{target: target, next: server.Client().Transport}
+	t.Cleanup(func() {
+		httpClient.Transport = saved
+		server.Close()
+	})
+}
+
+// redirectTransport sends every request to target instead of its own host.
+type redirectTransport struct {
+	target *url.URL
+	next   http.RoundTripper
+}
+
+func (t redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme, req.URL.Host = t.target.Scheme, t.target.Host
+	return t.next.RoundTrip(req)
+}
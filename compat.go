@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+// Manifest layouts accepted by -manifest-compat. Field names have never been
+// renamed, so an older layout is the current one minus the fields added
+// since; consumers that reject unknown fields can keep reading it.
+const (
+	compatV1 = "1" // the original layout: path, sha256, file_id and manifest_id per file
+)
+
+// checkManifestCompat validates -manifest-compat against the other flags.
+// Layout 1 has no hash_algo, so its digests are always read back as SHA-256.
+func checkManifestCompat(version string) error {
+	switch version {
+	case "":
+		return nil
+	case compatV1:
+		if hashAlgo != "sha256" {
+			return fmt.Errorf("-manifest-compat %s records no hash algorithm and requires -hash-algo sha256", version)
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported -manifest-compat version %q (supported: %s)", version, compatV1)
+}
+
+type manifestV1 struct {
+	ManifestID  string       `json:"manifest_id"`
+	Files       []fileInfoV1 `json:"files"`
+	LoggingInfo logInfoV1    `json:"log_info"`
+}
+
+type fileInfoV1 struct {
+	Path       string `json:"path"`
+	SHA256     string `json:"sha256"`
+	FileID     string `json:"file_id,omitempty"`
+	ManifestID string `json:"manifest_id,omitempty"`
+}
+
+type logInfoV1 struct {
+	GeneratedAt   string `json:"generated_at"`
+	OpenAIAPIKey  string `json:"openai_api_key"`
+	ScanFolder    string `json:"scan_folder"`
+	VectorStoreID string `json:"vector_store_id"`
+	Cleanup       bool   `json:"cleanup"`
+	DryRun        bool   `json:"dry_run"`
+	OutputFile    string `json:"output_file,omitempty"`
+}
+
+// legacyManifest converts a manifest to the -manifest-compat layout. Paths
+// stay absolute, as layout 1 has no scan_root to resolve them against.
+func legacyManifest(manifest Manifest) interface{} {
+	if len(manifest.PendingDeletes) > 0 {
+		slog.Warn("-manifest-compat layout has no pending_deletes; files awaiting cleanup are dropped from the manifest", "files", len(manifest.PendingDeletes))
+	}
+
+	legacy := manifestV1{ManifestID: manifest.ManifestID, Files: []fileInfoV1{}}
+	for _, fileInfo := range manifest.Files {
+		legacy.Files = append(legacy.Files, fileInfoV1{
+			Path:       fileInfo.Path,
+			SHA256:     fileInfo.SHA256,
+			FileID:     fileInfo.FileID,
+			ManifestID: fileInfo.ManifestID,
+		})
+	}
+	info := manifest.LoggingInfo
+	legacy.LoggingInfo = logInfoV1{
+		GeneratedAt:   info.GeneratedAt,
+		OpenAIAPIKey:  info.OpenAIAPIKey,
+		ScanFolder:    info.ScanFolder,
+		VectorStoreID: info.VectorStoreID,
+		Cleanup:       info.Cleanup,
+		DryRun:        info.DryRun,
+		OutputFile:    info.OutputFile,
+	}
+	return legacy
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// configEnv names the environment variables that take precedence over the
+// config file for the flags they back.
+var configEnv = map[string]string{
+	"vector-store-id": "OPENAI_VECTOR_STORE_ID",
+}
+
+// applyConfig sets flags from a -config file. The file is a JSON object of
+// named profiles, each mapping flag names to values; the "default" profile
+// applies first and the -profile one overrides it. Flags given on the command
+// line, and those whose environment variable is set, keep their values.
+func applyConfig(path, profile string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var profiles map[string]map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber() // keeps large integers such as byte sizes exact
+	if err := dec.Decode(&profiles); err != nil {
+		return fmt.Errorf("parsing -config %s: %w", path, err)
+	}
+	if _, ok := profiles[profile]; profile != "" && !ok {
+		return fmt.Errorf("-config %s has no profile %q", path, profile)
+	}
+
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	values := make(map[string]interface{})
+	for name, value := range profiles["default"] {
+		values[name] = value
+	}
+	for name, value := range profiles[profile] {
+		values[name] = value
+	}
+
+	for name, value := range values {
+		if flag.Lookup(name) == nil || name == "config" || name == "profile" {
+			return fmt.Errorf("-config %s: unknown option %q", path, name)
+		}
+		if explicit[name] || os.Getenv(configEnv[name]) != "" {
+			continue
+		}
+
+		// Lists set repeatable flags such as -tag once per element
+		items, ok := value.([]interface{})
+		if !ok {
+			items = []interface{}{value}
+		}
+		for _, item := range items {
+			if err := flag.Set(name, fmt.Sprint(item)); err != nil {
+				return fmt.Errorf("-config %s: %s: %w", path, name, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// confirmAction asks before a destructive action, stating its scope, e.g.
+// "delete 12 files from OpenAI". At a terminal the user answers y/N. When
+// stdin is not a terminal it refuses, so unattended runs must opt in with
+// -assume-yes instead.
+func confirmAction(scope string) bool {
+	if assumeYes {
+		slog.Info("confirmed by -assume-yes", "action", scope)
+		return true
+	}
+	if stat, err := os.Stdin.Stat(); err != nil || stat.Mode()&os.ModeCharDevice == 0 {
+		fmt.Printf("Refusing to %s without confirmation: stdin is not a terminal. Rerun with -assume-yes\n", scope)
+		return false
+	}
+
+	fmt.Printf("This will %s. Continue? [y/N] ", scope)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
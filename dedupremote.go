@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// remoteKey groups remote files that are presumably copies of each other.
+// Files uploaded for assistants can't be downloaded to compare their
+// content, so name and size stand in for it.
+type remoteKey struct {
+	filename string
+	bytes    int64
+}
+
+// dedupRemote deletes untracked remote files that duplicate a file the
+// manifest tracks, as left behind by runs that uploaded the same content
+// again. Only groups containing a tracked file are touched, so copies that
+// other manifests may track are left alone. Before a copy is deleted, the
+// tracked file takes its place in the vector store. The plan is printed
+// first; -dry-run stops there, and otherwise it must be confirmed, as
+// confirmAction does.
+func dedupRemote(manifest Manifest) {
+	remote, _, err := fetchRemoteFiles("")
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		exit(1)
+	}
+
+	tracked := make(map[string]FileInfo)
+	for _, fileInfo := range manifest.Files {
+		if fileInfo.FileID != "" {
+			tracked[fileInfo.FileID] = fileInfo
+		}
+	}
+	for _, fileInfo := range manifest.PendingDeletes {
+		tracked[fileInfo.FileID] = fileInfo
+	}
+
+	groups := make(map[remoteKey][]RemoteFile)
+	for _, file := range remote {
+		key := remoteKey{file.Filename, file.Bytes}
+		groups[key] = append(groups[key], file)
+	}
+
+	type redundant struct {
+		file RemoteFile
+		keep FileInfo
+	}
+	var plan []redundant
+	untouched := 0
+	for _, files := range groups {
+		if len(files) < 2 {
+			continue
+		}
+		var keep *FileInfo
+		for _, file := range files {
+			if fileInfo, ok := tracked[file.ID]; ok && fileInfo.Status != "failed" {
+				keep = &fileInfo
+				break
+			}
+		}
+		if keep == nil {
+			untouched++
+			continue
+		}
+		for _, file := range files {
+			if _, ok := tracked[file.ID]; !ok {
+				plan = append(plan, redundant{file: file, keep: *keep})
+			}
+		}
+	}
+	sort.Slice(plan, func(i, j int) bool {
+		if plan[i].keep.Path != plan[j].keep.Path {
+			return plan[i].keep.Path < plan[j].keep.Path
+		}
+		return plan[i].file.ID < plan[j].file.ID
+	})
+
+	for _, r := range plan {
+		fmt.Printf("%s: keep %s, delete duplicate %s (%s, %d bytes)\n", r.keep.Path, r.keep.FileID, r.file.ID, r.file.Filename, r.file.Bytes)
+	}
+	fmt.Printf("%d duplicate remote files to delete; %d groups of untracked duplicates left alone\n", len(plan), untouched)
+	if len(plan) == 0 || dryRun {
+		return
+	}
+	if !confirmAction(fmt.Sprintf("delete %d duplicate files from OpenAI", len(plan))) {
+		fmt.Println("Nothing deleted.")
+		exit(2)
+	}
+
+	// Membership is looked up once per vector store the kept files belong in
+	members := make(map[string]map[string]bool)
+	isMember := func(store, fileID string) (bool, error) {
+		if members[store] == nil {
+			files, err := listVectorStoreFiles(store)
+			if err != nil {
+				return false, err
+			}
+			members[store] = make(map[string]bool, len(files))
+			for _, file := range files {
+				members[store][file.ID] = true
+			}
+		}
+		return members[store][fileID], nil
+	}
+
+	failed := 0
+	for _, r := range plan {
+		err := func() error {
+			if store := storeFor(r.keep); store != "" {
+				inStore, err := isMember(store, r.file.ID)
+				if err != nil || !inStore {
+					return err
+				}
+				if keptInStore, err := isMember(store, r.keep.FileID); err != nil {
+					return err
+				} else if !keptInStore {
+					if _, err := createVectorStoreFile(store, r.keep.FileID, r.keep.Attributes); err != nil {
+						return err
+					}
+					members[store][r.keep.FileID] = true
+				}
+				if err := removeFromVectorStore(store, r.file.ID); err != nil {
+					return err
+				}
+			}
+			return nil
+		}()
+		if err == nil {
+			err = deleteFile(r.file.ID)
+		}
+		if err != nil {
+			fmt.Printf("Error deleting duplicate %s: %v\n", r.file.ID, err)
+			failed++
+			continue
+		}
+		fmt.Printf("Deleted duplicate FileID: %s\n", r.file.ID)
+		stats.filesDeleted.Add(1)
+	}
+	if failed > 0 {
+		exit(1)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+type DuplicateGroup struct {
+	Hash        string   `json:"hash"`
+	Size        int64    `json:"size"`
+	Paths       []string `json:"paths"`
+	WastedBytes int64    `json:"wasted_bytes"`
+}
+
+// reportDuplicates prints groups of byte-identical files in the manifest and
+// the bytes that would be saved by keeping only one copy of each.
+func reportDuplicates(manifest Manifest) {
+	byHash := make(map[string][]string)
+	for _, fileInfo := range manifest.Files {
+		byHash[fileInfo.SHA256] = append(byHash[fileInfo.SHA256], fileInfo.Path)
+	}
+
+	var groups []DuplicateGroup
+	var wasted int64
+	for hash, paths := range byHash {
+		if len(paths) < 2 {
+			continue
+		}
+		sort.Strings(paths)
+
+		var size int64
+		if stat, err := statPath(paths[0]); err == nil {
+			size = stat.Size()
+		}
+		group := DuplicateGroup{Hash: hash, Size: size, Paths: paths, WastedBytes: size * int64(len(paths)-1)}
+		groups = append(groups, group)
+		wasted += group.WastedBytes
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].WastedBytes != groups[j].WastedBytes {
+			return groups[i].WastedBytes > groups[j].WastedBytes
+		}
+		return groups[i].Hash < groups[j].Hash
+	})
+
+	if reportFormat == "json" {
+		data, _ := json.MarshalIndent(map[string]interface{}{"groups": groups, "wasted_bytes": wasted}, "", "  ")
+		fmt.Println(string(data))
+		return
+	}
+
+	for _, group := range groups {
+		fmt.Printf("%s (%d bytes, %d copies):\n", group.Hash, group.Size, len(group.Paths))
+		for _, path := range group.Paths {
+			fmt.Printf("  %s\n", path)
+		}
+	}
+	fmt.Printf("%d duplicate groups, %d bytes wasted\n", len(groups), wasted)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"io"
+)
+
+// sniffLen is how much of a file is inspected to decide whether it is text,
+// matching the heuristic git uses for binary detection.
+const sniffLen = 8000
+
+func isTextFile(filePath string) bool {
+	file, err := openPath(filePath)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	buf := make([]byte, sniffLen)
+	n, _ := io.ReadFull(file, buf)
+	return !bytes.Contains(buf[:n], []byte{0})
+}
+
+// openContent opens a file for hashing or uploading. With -normalize-eol, text
+// files are read with CRLF line endings converted to LF as they stream;
+// binary files are always returned untouched.
+func openContent(filePath string) (io.ReadCloser, error) {
+	file, err := openPath(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if !normalizeEOL || !isTextFile(filePath) {
+		return file, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{&eolReader{r: file, chunk: make([]byte, bufferSize)}, file}, nil
+}
+
+// contentSize returns how many bytes openContent reads from a file. A
+// normalized file is read through once to count them.
+func contentSize(filePath string) (int64, error) {
+	if !normalizeEOL || !isTextFile(filePath) {
+		stat, err := statPath(filePath)
+		if err != nil {
+			return 0, err
+		}
+		return stat.Size(), nil
+	}
+
+	file, err := openContent(filePath)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	return io.Copy(io.Discard, file)
+}
+
+// eolReader converts CRLF line endings in r to LF a chunk at a time. A CR
+// ending a chunk is held back until the next one shows whether an LF
+// follows it.
+type eolReader struct {
+	r       io.Reader
+	chunk   []byte
+	pending []byte // converted bytes not yet returned
+	cr      bool   // a CR was held back
+	err     error
+}
+
+func (e *eolReader) Read(p []byte) (int, error) {
+	for len(e.pending) == 0 {
+		if e.err != nil {
+			return 0, e.err
+		}
+		e.fill()
+	}
+	n := copy(p, e.pending)
+	e.pending = e.pending[n:]
+	return n, nil
+}
+
+func (e *eolReader) fill() {
+	start := 0
+	if e.cr {
+		e.chunk[0], start, e.cr = '\r', 1, false
+	}
+	n, err := e.r.Read(e.chunk[start:])
+	e.err = err
+	data := e.chunk[:start+n]
+
+	// Converted in place, as the output never gets ahead of the input
+	out := data[:0]
+	for i := 0; i < len(data); i++ {
+		if data[i] == '\r' {
+			if i+1 < len(data) && data[i+1] == '\n' {
+				continue
+			}
+			if i+1 == len(data) && err == nil {
+				e.cr = true
+				break
+			}
+		}
+		out = append(out, data[i])
+	}
+	e.pending = out
+}
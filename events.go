@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Event is one line of the -events stream. Fields are only ever added, so
+// consumers can rely on the existing ones.
+type Event struct {
+	Time          string `json:"time"`
+	Type          string `json:"type"` // hashed, uploaded, upload_failed, indexed, attributes_updated, deleted or delete_failed
+	Path          string `json:"path,omitempty"`
+	FileID        string `json:"file_id,omitempty"`
+	VectorStoreID string `json:"vector_store_id,omitempty"`
+	Digest        string `json:"digest,omitempty"`
+	Bytes         int64  `json:"bytes,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+// eventStream writes events as JSON lines; it is nil unless -events is set.
+type eventStream struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+var events *eventStream
+
+// openEvents opens the -events-file, or stdout when there is none. Stdout
+// then carries only events, so everything else printed there, including a
+// manifest without -output, goes to stderr instead.
+func openEvents(path string) (*eventStream, error) {
+	var w io.Writer = os.Stdout
+	if path == "" {
+		os.Stdout = os.Stderr
+	} else {
+		// Opened for appending so a named pipe or a shared log works as well as a regular file
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		w = file
+	}
+	return &eventStream{enc: json.NewEncoder(w)}, nil
+}
+
+func (s *eventStream) emit(event Event) {
+	if s == nil {
+		return
+	}
+	event.Time = time.Now().UTC().Format(time.RFC3339Nano)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.enc.Encode(event)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+)
+
+// exclusions holds the paths and digests tracked by a sibling manifest so
+// files it already handles are not uploaded again by this run.
+type exclusions struct {
+	paths   map[string]bool
+	digests map[string]bool // keyed by algo:digest so mixed algorithms never collide
+}
+
+var excluded *exclusions
+
+func loadExclusions(path string) *exclusions {
+	sibling, err := loadManifest(path)
+	if err != nil {
+		fmt.Printf("Error: reading exclude manifest %s: %v\n", path, err)
+		exit(2)
+	}
+
+	e := &exclusions{paths: make(map[string]bool), digests: make(map[string]bool)}
+	for _, fileInfo := range sibling.Files {
+		e.paths[fileInfo.Path] = true
+		e.digests[fileInfo.hashAlgo()+":"+fileInfo.SHA256] = true
+	}
+	return e
+}
+
+func (e *exclusions) hasPath(path string) bool {
+	return e != nil && e.paths[path]
+}
+
+func (e *exclusions) hasDigest(algo, digest string) bool {
+	return e != nil && e.digests[algo+":"+digest]
+}
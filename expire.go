@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// expireOldFiles deletes tracked files uploaded before cutoff from OpenAI and
+// the vector store, and drops them from the manifest. The upload time comes
+// from the manifest, or from the remote created_at for entries written before
+// it was recorded. Deletions that fail keep their entry for a later run.
+func expireOldFiles(manifest Manifest, cutoff time.Time) {
+	var expired, kept []FileInfo
+	for _, fileInfo := range manifest.Files {
+		if fileInfo.FileID == "" {
+			kept = append(kept, fileInfo)
+			continue
+		}
+
+		uploadedAt, err := uploadTime(fileInfo)
+		if err != nil {
+			slog.Warn("could not determine upload time, keeping file", "path", fileInfo.Path, "file_id", fileInfo.FileID, "error", err)
+			kept = append(kept, fileInfo)
+			continue
+		}
+		if !uploadedAt.Before(cutoff) {
+			kept = append(kept, fileInfo)
+			continue
+		}
+
+		if dryRun {
+			fmt.Printf("Would expire FileID: %s (%s, uploaded %s)\n", fileInfo.FileID, fileInfo.Path, uploadedAt.Format(time.RFC3339))
+			kept = append(kept, fileInfo)
+			continue
+		}
+		expired = append(expired, fileInfo)
+	}
+
+	if dryRun {
+		return
+	}
+	if len(expired) > 0 && !confirmAction(fmt.Sprintf("delete %d files uploaded before %s from OpenAI and their vector stores", len(expired), cutoff.Format(time.RFC3339))) {
+		fmt.Println("Nothing deleted.")
+		exit(2)
+	}
+	failed := performCleanup(expired)
+	kept = append(kept, failed...)
+
+	fmt.Printf("Expired %d files uploaded before %s\n", len(expired)-len(failed), cutoff.Format(time.RFC3339))
+	if !dryRunHTTP {
+		manifest.Files = kept
+		saveOrPrintManifest(manifest, output)
+	}
+}
+
+func uploadTime(fileInfo FileInfo) (time.Time, error) {
+	if fileInfo.UploadedAt != "" {
+		return time.Parse(time.RFC3339, fileInfo.UploadedAt)
+	}
+	remote, err := getFile(fileInfo.FileID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(remote.CreatedAt, 0), nil
+}
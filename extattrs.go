@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"path/filepath"
+	"strings"
+)
+
+// defaultFormats gives the "format" attribute set on files by extension with
+// -ext-format defaults, so they can be filtered by type without a sidecar
+// for each.
+var defaultFormats = map[string]string{
+	"md":       "markdown",
+	"markdown": "markdown",
+	"pdf":      "pdf",
+	"txt":      "text",
+	"html":     "html",
+	"htm":      "html",
+	"json":     "json",
+	"jsonl":    "jsonl",
+	"csv":      "csv",
+	"docx":     "docx",
+	"pptx":     "pptx",
+	"py":       "python",
+	"go":       "go",
+	"js":       "javascript",
+	"ts":       "typescript",
+}
+
+var formats map[string]string
+
+// parseFormatMap parses -ext-format. No extension has a format unless it is
+// mapped, or "defaults" adds the built-in formats, which later entries can
+// override. An empty format drops an extension's earlier mapping.
+func parseFormatMap(spec string) (map[string]string, error) {
+	mapping := map[string]string{}
+	if spec == "" || spec == "none" {
+		return mapping, nil
+	}
+
+	for _, pair := range strings.Split(spec, ",") {
+		if strings.TrimSpace(pair) == "defaults" {
+			maps.Copy(mapping, defaultFormats)
+			continue
+		}
+		ext, format, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		ext = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), "."))
+		format = strings.TrimSpace(format)
+		if !ok || ext == "" {
+			return nil, fmt.Errorf("invalid -ext-format entry: %q", pair)
+		}
+		if format == "" {
+			delete(mapping, ext)
+		} else {
+			mapping[ext] = format
+		}
+	}
+	return mapping, nil
+}
+
+// defaultAttributes returns the attributes a file gets from its extension,
+// or nil when it has none.
+func defaultAttributes(path string) map[string]interface{} {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+	if format, ok := formats[ext]; ok {
+		return map[string]interface{}{"format": format}
+	}
+	return nil
+}
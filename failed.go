@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log/slog"
+)
+
+type FailedFile struct {
+	Path   string `json:"path"`
+	FileID string `json:"file_id,omitempty"`
+	Stage  string `json:"stage"` // upload, index or delete
+	Error  string `json:"error"`
+}
+
+// listFailed prints the manifest entries whose last attempt failed, without
+// any API calls.
+func listFailed(manifest Manifest) {
+	failed := []FailedFile{}
+	for _, fileInfo := range manifest.Files {
+		// An entry with a FileID was uploaded, so it was indexing that failed
+		stage := "upload"
+		if fileInfo.FileID != "" {
+			stage = "index"
+		}
+		if fileInfo.Status == "failed" {
+			failed = append(failed, FailedFile{Path: fileInfo.Path, FileID: fileInfo.FileID, Stage: stage, Error: fileInfo.Error})
+		}
+	}
+	for _, fileInfo := range manifest.PendingDeletes {
+		if fileInfo.Status == "failed" {
+			failed = append(failed, FailedFile{Path: fileInfo.Path, FileID: fileInfo.FileID, Stage: "delete", Error: fileInfo.Error})
+		}
+	}
+
+	if reportFormat == "json" {
+		data, _ := json.MarshalIndent(failed, "", "  ")
+		fmt.Println(string(data))
+		return
+	}
+
+	for _, file := range failed {
+		fmt.Printf("%s (%s): %s\n", file.Path, file.Stage, file.Error)
+	}
+	fmt.Printf("%d failed files\n", len(failed))
+}
+
+// retryFailed processes only the manifest entries that failed or were never
+// uploaded, without scanning for other changes. Pending entries are rehashed
+// first, as their file may have changed since; those whose file is gone are
+// left alone. With -cleanup, failed deletions are retried as well.
+func retryFailed(fsys fs.FS, root string, manifest Manifest) {
+	contentFS, contentRoot = fsys, root
+
+	var pending []int
+	for i, fileInfo := range manifest.Files {
+		if fileInfo.FileID != "" && fileInfo.Status != "failed" {
+			continue
+		}
+		if _, err := statPath(fileInfo.Path); err != nil {
+			slog.Warn("skipping retry of missing file", "path", fileInfo.Path, "error", err)
+			continue
+		}
+		if fileInfo.FileID == "" {
+			hash, err := hashFile(fileInfo.Path, fileInfo.hashAlgo())
+			if err != nil {
+				slog.Warn("skipping retry of unreadable file", "path", fileInfo.Path, "error", err)
+				continue
+			}
+			manifest.Files[i].SHA256 = hash
+		}
+		pending = append(pending, i)
+	}
+
+	var retryDeletes, keep []FileInfo
+	for _, fileInfo := range manifest.PendingDeletes {
+		if cleanup && fileInfo.Status == "failed" {
+			retryDeletes = append(retryDeletes, fileInfo)
+		} else {
+			keep = append(keep, fileInfo)
+		}
+	}
+
+	fmt.Printf("Retrying %d files and %d deletions\n", len(pending), len(retryDeletes))
+	if dryRun {
+		return
+	}
+	failed, err := uploadFiles(manifest, pending)
+	if err == nil {
+		manifest.PendingDeletes = append(keep, performCleanup(retryDeletes)...)
+	}
+	manifest.LoggingInfo.Skipped = failed
+
+	if !dryRunHTTP {
+		saveOrPrintManifest(manifest, output)
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		exit(1)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// fileIDPaths are tried in order when -file-id-path isn't set: the OpenAI
+// response has the ID at the top level, while some compatible gateways wrap
+// the file object in a data envelope.
+var fileIDPaths = []string{"id", "data.id", "data.0.id"}
+
+// checkFileIDPath checks a -file-id-path, a dot-separated list of object
+// keys and array indexes.
+func checkFileIDPath(path string) error {
+	for _, key := range strings.Split(path, ".") {
+		if key == "" {
+			return fmt.Errorf("-file-id-path %q has an empty segment", path)
+		}
+	}
+	return nil
+}
+
+// extractFileID returns the file ID in an upload response, from
+// -file-id-path when set or else the first of fileIDPaths holding one.
+func extractFileID(body []byte) (string, error) {
+	var result interface{}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", fmt.Errorf("response is not JSON: %w", err)
+	}
+
+	paths := fileIDPaths
+	if fileIDPath != "" {
+		paths = []string{fileIDPath}
+	}
+	for _, path := range paths {
+		if id := lookupJSON(result, path); id != "" {
+			return id, nil
+		}
+	}
+	return "", fmt.Errorf("no file ID at %s", strings.Join(paths, ", "))
+}
+
+// lookupJSON follows path through decoded JSON, returning the string found
+// at its end or "" when there is none. Numeric IDs are returned as written.
+func lookupJSON(value interface{}, path string) string {
+	for _, key := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			value = v[key]
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return ""
+			}
+			value = v[i]
+		default:
+			return ""
+		}
+	}
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return ""
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestUploadFileIDEnvelopes(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		path     string
+		wantID   string
+		wantErr  string
+	}{
+		{"top level", `{"id":"file-top","object":"file"}`, "", "file-top", ""},
+		{"data object", `{"data":{"id":"file-obj"}}`, "", "file-obj", ""},
+		{"data array", `{"object":"list","data":[{"id":"file-arr"}]}`, "", "file-arr", ""},
+		{"top level first", `{"id":"file-top","data":{"id":"file-obj"}}`, "", "file-top", ""},
+		{"custom path", `{"id":"req-1","result":{"file":{"id":"file-custom"}}}`, "result.file.id", "file-custom", ""},
+		{"custom path index", `{"files":[{"id":"file-0"},{"id":"file-1"}]}`, "files.1.id", "file-1", ""},
+		{"numeric id", `{"result":{"file_id":42}}`, "result.file_id", "42", ""},
+		{"custom path only", `{"id":"file-top"}`, "result.file.id", "", "no file ID at result.file.id"},
+		{"no id", `{"object":"file"}`, "", "", "no file ID at id, data.id, data.0.id"},
+		{"not json", `OK`, "", "", "response is not JSON"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saved := fileIDPath
+			fileIDPath = tt.path
+			t.Cleanup(func() { fileIDPath = saved })
+			serveAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(tt.response))
+			})
+
+			fileID, err := UploadContent(context.Background(), "a.txt", bytes.NewReader([]byte("a")), "assistants")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fileID != tt.wantID {
+				t.Errorf("file ID = %q, want %q", fileID, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestCheckFileIDPath(t *testing.T) {
+	for _, path := range []string{"id", "data.0.id", "result.file.id"} {
+		if err := checkFileIDPath(path); err != nil {
+			t.Errorf("checkFileIDPath(%q) = %v", path, err)
+		}
+	}
+	for _, path := range []string{"data..id", ".id", "id."} {
+		if err := checkFileIDPath(path); err == nil {
+			t.Errorf("checkFileIDPath(%q) accepted an empty segment", path)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// filenames maps files to the names they are uploaded under, from -map-file.
+// Keys are manifest paths or paths relative to the scanned folder.
+var filenames map[string]string
+
+func loadFilenameMap(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var mapping map[string]string
+	if err := json.Unmarshal(data, &mapping); err != nil {
+		return nil, fmt.Errorf("parsing -map-file %s: %w", path, err)
+	}
+	for key, name := range mapping {
+		if name == "" {
+			return nil, fmt.Errorf("-map-file %s: empty filename for %s", path, key)
+		}
+	}
+	return mapping, nil
+}
+
+// mappedFilename returns the -map-file name for a file, or "" if it has none.
+func mappedFilename(path string) string {
+	if name, ok := filenames[path]; ok {
+		return name
+	}
+	return filenames[fsPath(path)]
+}
+
+// uploadName is the filename OpenAI shows for the file: its mapped name, or
+// else the base name of its path.
+func (f FileInfo) uploadName() string {
+	if f.Filename != "" {
+		return f.Filename
+	}
+	return filepath.Base(f.Path)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// invalidFineTuneReason starts the skip reason of files failing -validate-finetune.
+const invalidFineTuneReason = "invalid fine-tune data"
+
+var chatRoles = map[string]bool{"system": true, "developer": true, "user": true, "assistant": true, "tool": true}
+
+// isFineTuneFile reports whether a file is JSONL uploaded for fine-tuning,
+// which -validate-finetune checks.
+func isFineTuneFile(fileInfo FileInfo) bool {
+	purpose := fileInfo.Purpose
+	if purpose == "" {
+		purpose = purposeFor(fileInfo.Path)
+	}
+	return purpose == "fine-tune" && strings.EqualFold(filepath.Ext(fileInfo.Path), ".jsonl")
+}
+
+// checkFineTune checks that every line of a fine-tuning file is an example in
+// the chat format, {"messages": [...]}, or the legacy completion format,
+// {"prompt": "...", "completion": "..."}, using one format throughout. It
+// returns the first problem found, with its line number.
+func checkFineTune(path string) error {
+	file, err := openPath(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	format := ""
+	examples := 0
+	r := bufio.NewReader(file)
+	for lineNo := 1; ; lineNo++ {
+		line, readErr := r.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			return readErr
+		}
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			lineFormat, err := checkFineTuneExample(line)
+			if err != nil {
+				return fmt.Errorf("line %d: %v", lineNo, err)
+			}
+			if format != "" && lineFormat != format {
+				return fmt.Errorf("line %d: %s example in a file of %s examples", lineNo, lineFormat, format)
+			}
+			format = lineFormat
+			examples++
+		}
+		if readErr == io.EOF {
+			break
+		}
+	}
+	if examples == 0 {
+		return fmt.Errorf("no examples")
+	}
+	return nil
+}
+
+// checkFineTuneExample checks one example, returning its format.
+func checkFineTuneExample(line []byte) (string, error) {
+	var example map[string]json.RawMessage
+	if err := json.Unmarshal(line, &example); err != nil {
+		return "", fmt.Errorf("not a JSON object")
+	}
+
+	if raw, ok := example["messages"]; ok {
+		var messages []map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &messages); err != nil || len(messages) == 0 {
+			return "chat", fmt.Errorf(`"messages" must be a non-empty array of message objects`)
+		}
+		assistant := false
+		for i, message := range messages {
+			var role string
+			if err := json.Unmarshal(message["role"], &role); err != nil || !chatRoles[role] {
+				return "chat", fmt.Errorf("message %d: missing or unknown role %s", i+1, message["role"])
+			}
+			assistant = assistant || role == "assistant"
+
+			// Assistant messages calling tools may have no content
+			content := bytes.TrimSpace(message["content"])
+			_, calls := message["tool_calls"]
+			_, call := message["function_call"]
+			switch {
+			case len(content) == 0 || string(content) == "null":
+				if role != "assistant" || !calls && !call {
+					return "chat", fmt.Errorf("message %d: missing content", i+1)
+				}
+			case content[0] != '"' && content[0] != '[':
+				return "chat", fmt.Errorf("message %d: content must be a string or an array of parts", i+1)
+			}
+		}
+		if !assistant {
+			return "chat", fmt.Errorf("no assistant message to learn from")
+		}
+		return "chat", nil
+	}
+
+	var prompt, completion string
+	if json.Unmarshal(example["prompt"], &prompt) != nil || json.Unmarshal(example["completion"], &completion) != nil {
+		return "", fmt.Errorf(`expected "messages" (chat format) or string "prompt" and "completion" (completion format)`)
+	}
+	return "completion", nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// contentFS is the filesystem files are scanned, hashed and uploaded from.
+// Manifest paths are contentRoot joined with the file's path inside it, so
+// manifests stay compatible with those written from a plain folder walk.
+var (
+	contentFS   fs.FS
+	contentRoot string
+)
+
+func fsPath(path string) string {
+	rel, err := filepath.Rel(contentRoot, path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	return filepath.ToSlash(rel)
+}
+
+func manifestPath(name string) string {
+	return filepath.Join(contentRoot, filepath.FromSlash(name))
+}
+
+// -files paths are read from the local disk wherever they are, as they need
+// not be inside the folder.
+var (
+	explicitFiles   listFlag
+	explicitPaths   []string
+	explicitPathSet map[string]bool
+)
+
+// parseExplicitFiles sets explicitPaths from the -files values, each a
+// comma-separated list, dropping repeats.
+func parseExplicitFiles() {
+	explicitPathSet = make(map[string]bool)
+	for _, value := range explicitFiles {
+		for _, path := range strings.Split(value, ",") {
+			if path = strings.TrimSpace(path); path == "" {
+				continue
+			}
+			path = filepath.Clean(path)
+			if !explicitPathSet[path] {
+				explicitPathSet[path] = true
+				explicitPaths = append(explicitPaths, path)
+			}
+		}
+	}
+}
+
+func openPath(path string) (fs.File, error) {
+	if explicitPathSet[path] {
+		return os.Open(path)
+	}
+	return contentFS.Open(fsPath(path))
+}
+
+func statPath(path string) (fs.FileInfo, error) {
+	if explicitPathSet[path] {
+		return os.Stat(path)
+	}
+	return fs.Stat(contentFS, fsPath(path))
+}
+
+func readPath(path string) ([]byte, error) {
+	if explicitPathSet[path] {
+		return os.ReadFile(path)
+	}
+	return fs.ReadFile(contentFS, fsPath(path))
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// hashCache remembers digests across runs, keyed by file identity
+// (device, inode, size and mtime) so unchanged files are not re-read.
+type hashCache struct {
+	mu      sync.Mutex
+	path    string
+	entries map[string]string
+	seen    map[string]string
+}
+
+var cache *hashCache
+
+func loadHashCache(path string) *hashCache {
+	c := &hashCache{path: path, entries: make(map[string]string), seen: make(map[string]string)}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return c
+	}
+	if err := json.Unmarshal(data, &c.entries); err != nil {
+		fmt.Printf("Ignoring unreadable hash cache %s: %v\n", path, err)
+		c.entries = make(map[string]string)
+	}
+	return c
+}
+
+func (c *hashCache) lookup(info os.FileInfo, algo string) (string, bool) {
+	key, ok := hashCacheKey(info, algo)
+	if !ok {
+		return "", false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	digest, ok := c.entries[key]
+	if ok {
+		c.seen[key] = digest
+	}
+	return digest, ok
+}
+
+func (c *hashCache) store(info os.FileInfo, algo string, digest string) {
+	key, ok := hashCacheKey(info, algo)
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = digest
+	c.seen[key] = digest
+}
+
+// save writes only the entries touched during this run, dropping those whose
+// file identity changed or which no longer exist.
+func (c *hashCache) save() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	data, _ := json.Marshal(c.seen)
+	if err := ioutil.WriteFile(c.path, data, 0644); err != nil {
+		fmt.Printf("Error writing hash cache %s: %v\n", c.path, err)
+	}
+}
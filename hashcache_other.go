@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// hashCacheKey has no stable file identity to key on outside unix, so the
+// cache is never consulted there.
+func hashCacheKey(info os.FileInfo, algo string) (string, bool) {
+	return "", false
+}
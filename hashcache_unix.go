@@ -0,0 +1,17 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+func hashCacheKey(info os.FileInfo, algo string) (string, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf("%d:%d:%d:%d:%s", stat.Dev, stat.Ino, info.Size(), info.ModTime().UnixNano(), algo), true
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// apiCheckInterval bounds how often /readyz calls the API, however often it is probed.
+const apiCheckInterval = 30 * time.Second
+
+// healthState is what the -health-addr endpoints report on: the outcome of
+// the latest watch sync and of the latest API connectivity check.
+type healthState struct {
+	mu        sync.Mutex
+	synced    bool // a sync has finished
+	syncErr   error
+	syncedAt  time.Time
+	checkedAt time.Time
+	apiErr    error
+}
+
+var health = &healthState{}
+
+func (h *healthState) recordSync(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.synced, h.syncErr, h.syncedAt = true, err, time.Now()
+}
+
+// ready returns why the daemon is not ready, or nil when the last sync
+// succeeded and the API is reachable. The API is called without holding the
+// lock, so other probes answer from the previous check meanwhile.
+func (h *healthState) ready(ctx context.Context) error {
+	h.mu.Lock()
+	synced, syncErr, syncedAt, apiErr := h.synced, h.syncErr, h.syncedAt, h.apiErr
+	check := synced && syncErr == nil && time.Since(h.checkedAt) >= apiCheckInterval
+	if check {
+		h.checkedAt = time.Now()
+	}
+	h.mu.Unlock()
+
+	if !synced {
+		return fmt.Errorf("no sync has finished yet")
+	}
+	if syncErr != nil {
+		return fmt.Errorf("last sync at %s failed: %v", syncedAt.Format(time.RFC3339), syncErr)
+	}
+	if check {
+		apiErr = pingAPI(ctx)
+		h.mu.Lock()
+		h.apiErr = apiErr
+		h.mu.Unlock()
+	}
+	if apiErr != nil {
+		return fmt.Errorf("API unreachable: %v", apiErr)
+	}
+	return nil
+}
+
+// pingAPI makes one cheap authenticated request, without retries, to check
+// the API is reachable and accepts the key.
+func pingAPI(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", "https://api.openai.com/v1/models", nil)
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Non-OK HTTP status: %s", resp.Status)
+	}
+	return nil
+}
+
+// serveHealth serves /healthz, which answers while the process runs, /readyz,
+// which fails while the daemon can't sync, and /metrics. The server runs in
+// the background; a failure to listen is fatal.
+func serveHealth(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if err := health.ready(r.Context()); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	mux.Handle("/metrics", metricsHandler())
+
+	go func() {
+		slog.Info("serving health checks", "addr", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			fmt.Printf("Error serving health checks on %s: %v\n", addr, err)
+			exit(1)
+		}
+	}()
+}
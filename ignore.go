@@ -0,0 +1,40 @@
+package main
+
+import (
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// defaultIgnoredDirs are the version control and build directories -ignore-vcs
+// prunes from the walk unless -ignore-dirs replaces them.
+const defaultIgnoredDirs = ".git,.svn,.hg,.bzr,node_modules,__pycache__,.venv,.tox,.mypy_cache,.pytest_cache"
+
+var ignoredDirs map[string]bool
+
+func parseIgnoredDirs(spec string) map[string]bool {
+	dirs := make(map[string]bool)
+	for _, name := range strings.Split(spec, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			dirs[name] = true
+		}
+	}
+	return dirs
+}
+
+// ignoredDir reports whether a walked entry is a directory to prune. The
+// scan root itself is never pruned.
+func ignoredDir(name string, d fs.DirEntry) bool {
+	return d.IsDir() && name != "." && ignoredDirs[d.Name()]
+}
+
+// inIgnoredDir reports whether name lies below a directory to prune, for
+// paths reached without a walk from the root.
+func inIgnoredDir(name string) bool {
+	for _, dir := range strings.Split(path.Dir(name), "/") {
+		if ignoredDirs[dir] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// invalidJSONReason starts the skip reason of files failing -validate-json.
+const invalidJSONReason = "invalid JSON"
+
+// checkJSON parses .json and .jsonl files, returning where the first error
+// is. Other files are not checked.
+func checkJSON(path string) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		data, err := readPath(path)
+		if err != nil {
+			return err
+		}
+		var value json.RawMessage
+		if err := json.Unmarshal(data, &value); err != nil {
+			var syntaxErr *json.SyntaxError
+			if errors.As(err, &syntaxErr) {
+				line, col := position(data, syntaxErr.Offset)
+				return fmt.Errorf("line %d, column %d: %v", line, col, err)
+			}
+			return err
+		}
+	case ".jsonl":
+		file, err := openPath(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		r := bufio.NewReader(file)
+		for lineNo := 1; ; lineNo++ {
+			line, err := r.ReadBytes('\n')
+			if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 && !json.Valid(trimmed) {
+				return fmt.Errorf("line %d: not valid JSON", lineNo)
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// position converts a byte offset into a 1-based line and column.
+func position(data []byte, offset int64) (line, col int) {
+	before := data[:min(offset, int64(len(data)))]
+	line = bytes.Count(before, []byte("\n")) + 1
+	col = len(before) - bytes.LastIndexByte(before, '\n')
+	return line, col
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// logSink is the -log-file, if any; main closes it on the way out so queued
+// lines are written.
+var logSink *logFile
+
+var exitHooks []func(code int)
+
+// onExit registers hook to run with the exit code when the process ends,
+// whether main returns or exit is called.
+func onExit(hook func(code int)) {
+	exitHooks = append(exitHooks, hook)
+}
+
+// runExitHooks runs the registered hooks once; main defers it with 0 for a
+// normal return.
+func runExitHooks(code int) {
+	hooks := exitHooks
+	exitHooks = nil
+	for _, hook := range hooks {
+		hook(code)
+	}
+}
+
+// exit ends the process with code once the exit hooks have run and the
+// -log-file has been written out, as os.Exit skips the deferred calls in
+// main. Every exit goes through it.
+func exit(code int) {
+	runExitHooks(code)
+	logSink.close()
+	os.Exit(code)
+}
+
+// logOutput returns where logs go: stderr unless -quiet, plus the -log-file.
+func logOutput() (io.Writer, error) {
+	var w io.Writer = os.Stderr
+	if quiet {
+		w = io.Discard
+	}
+	if logFilePath == "" {
+		return w, nil
+	}
+
+	sink, err := openLogFile(logFilePath, logMaxSize)
+	if err != nil {
+		return nil, err
+	}
+	logSink = sink
+	return io.MultiWriter(w, sink), nil
+}
+
+// logFile appends log lines to a file from a background goroutine, so a slow
+// disk never holds up the run. Lines are queued, and dropped if the queue is
+// full, with a count of them written at the end. Once the file would grow
+// past maxSize it is renamed with a .1 suffix, replacing any earlier one, and
+// a new file is started.
+type logFile struct {
+	path    string
+	maxSize int64
+	file    *os.File
+	size    int64
+	lines   chan []byte
+	dropped atomic.Int64
+
+	mu     sync.Mutex // guards closed, so no line is queued after close
+	closed bool
+	done   chan struct{}
+}
+
+func openLogFile(path string, maxSize int64) (*logFile, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	l := &logFile{path: path, maxSize: maxSize, file: file, size: stat.Size(), lines: make(chan []byte, 4096), done: make(chan struct{})}
+	go l.run()
+	return l, nil
+}
+
+// Write queues a copy of p, as slog reuses its buffer. It never fails.
+func (l *logFile) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return len(p), nil
+	}
+	select {
+	case l.lines <- append([]byte(nil), p...):
+	default:
+		l.dropped.Add(1)
+	}
+	return len(p), nil
+}
+
+func (l *logFile) run() {
+	defer close(l.done)
+	for line := range l.lines {
+		if l.maxSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+			l.rotate()
+		}
+		n, _ := l.file.Write(line)
+		l.size += int64(n)
+	}
+}
+
+func (l *logFile) rotate() {
+	l.file.Close()
+	os.Rename(l.path, l.path+".1")
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		// Keep writing to the rotated file rather than lose the log
+		file, _ = os.OpenFile(l.path+".1", os.O_WRONLY|os.O_APPEND, 0644)
+	}
+	l.file, l.size = file, 0
+}
+
+// close writes the queued lines and closes the file. It is safe on a nil
+// logFile and to call more than once.
+func (l *logFile) close() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return
+	}
+	l.closed = true
+	close(l.lines)
+	l.mu.Unlock()
+
+	<-l.done
+	if n := l.dropped.Load(); n > 0 {
+		fmt.Fprintf(l.file, "%d log lines dropped because the log file could not keep up\n", n)
+	}
+	l.file.Close()
+}
@@ -2,50 +2,243 @@ package main
 
 import (
 	"bytes"
+	"cmp"
+	"context"
 	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"hash"
 	"io"
+	"io/fs"
 	"io/ioutil"
+	"log/slog"
+	"maps"
+	"math/rand/v2"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
+
+	"golang.org/x/crypto/blake2b"
 )
 
 type FileInfo struct {
-	Path       string `json:"path"`
-	SHA256     string `json:"sha256"`
-	FileID     string `json:"file_id,omitempty"`
-	ManifestID string `json:"manifest_id,omitempty"`
+	Path          string                 `json:"path"`
+	SHA256        string                 `json:"sha256"` // digest computed with HashAlgo; the name predates configurable algorithms
+	HashAlgo      string                 `json:"hash_algo,omitempty"`
+	FileID        string                 `json:"file_id,omitempty"`
+	Purpose       string                 `json:"purpose,omitempty"`
+	Upload        *UploadState           `json:"upload,omitempty"`
+	NormalizedEOL bool                   `json:"normalized_eol,omitempty"`
+	ExpiresAfter  *ExpiresAfter          `json:"expires_after,omitempty"`
+	Attributes    map[string]interface{} `json:"attributes,omitempty"`
+	ManifestID    string                 `json:"manifest_id,omitempty"`
+	VectorStoreID string                 `json:"vector_store_id,omitempty"` // set by a -rules match; otherwise -vector-store-id applies
+	Rule          string                 `json:"rule,omitempty"`            // pattern of the -rules entry that routed the file
+	UploadedAt    string                 `json:"uploaded_at,omitempty"`
+	Filename      string                 `json:"filename,omitempty"` // name given by -map-file; otherwise the base name of Path
+	Bytes         int64                  `json:"bytes,omitempty"`    // size when last scanned
+
+	// Vector store file created for the upload, and its status when it was added
+	VectorStoreFileID string `json:"vector_store_file_id,omitempty"`
+	VectorStoreStatus string `json:"vector_store_status,omitempty"`
+	IndexedAt         string `json:"indexed_at,omitempty"`
+
+	// IdempotencyKey was sent with the last upload of the file, so a retried
+	// upload that had in fact succeeded is not created twice
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+
+	// AttributesPending is set when only Attributes changed since the upload,
+	// until they are applied to the vector store file in place
+	AttributesPending bool `json:"attributes_pending,omitempty"`
+
+	// Status is "failed" when the last attempt to upload, index or delete the
+	// file failed under -continue-on-error, with the reason in Error
+	Status string `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ExpiresAfter is the expiration policy OpenAI applies to an uploaded file.
+type ExpiresAfter struct {
+	Anchor  string `json:"anchor"`
+	Seconds int64  `json:"seconds"`
 }
 
 type Manifest struct {
-	ManifestID  string     `json:"manifest_id"`
-	Files       []FileInfo `json:"files"`
-	LoggingInfo LogInfo    `json:"log_info"`
+	ManifestID     string     `json:"manifest_id"`
+	Files          []FileInfo `json:"files"`
+	PendingDeletes []FileInfo `json:"pending_deletes,omitempty"` // uploaded files no longer tracked that cleanup has yet to delete
+
+	// CaseInsensitivePaths records that paths are matched regardless of case (-case-insensitive-paths)
+	CaseInsensitivePaths bool `json:"case_insensitive_paths,omitempty"`
+
+	// ReindexStartedAt is when an unfinished -reindex-all began; files indexed since are done
+	ReindexStartedAt string `json:"reindex_started_at,omitempty"`
+
+	// ScanRoot is the absolute folder that relative entry paths are under (-relative-paths)
+	ScanRoot    string  `json:"scan_root,omitempty"`
+	LoggingInfo LogInfo `json:"log_info"`
 }
 
 type LogInfo struct {
-	GeneratedAt   string `json:"generated_at"`
-	OpenAIAPIKey  string `json:"openai_api_key"`
-	ScanFolder    string `json:"scan_folder"`
-	VectorStoreID string `json:"vector_store_id"`
-	Cleanup       bool   `json:"cleanup"`
-	DryRun        bool   `json:"dry_run"`
-	OutputFile    string `json:"output_file,omitempty"`
+	GeneratedAt      string            `json:"generated_at"`
+	ScanStartedAt    string            `json:"scan_started_at,omitempty"` // files modified before it were hashed as they are now
+	OpenAIAPIKey     string            `json:"openai_api_key"`
+	ScanFolder       string            `json:"scan_folder"`
+	VectorStoreID    string            `json:"vector_store_id"`
+	Cleanup          bool              `json:"cleanup"`
+	DryRun           bool              `json:"dry_run"`
+	OutputFile       string            `json:"output_file,omitempty"`
+	Skipped          []SkippedFile     `json:"skipped,omitempty"`
+	Changes          int               `json:"changes"` // uploads, attribute updates and deletions the run planned
+	AttributeUpdates int               `json:"attribute_updates,omitempty"`
+	Tags             map[string]string `json:"tags,omitempty"`
+	StripPrefix      string            `json:"strip_prefix,omitempty"` // removed from the entry paths under it (-strip-prefix)
+	RemoteDiff       *RemoteDiff       `json:"remote_diff,omitempty"`
+
+	// Interrupted is set when the run stopped early, by -run-timeout or a signal, leaving
+	// some files unscanned, unuploaded or undeleted; InterruptReason says why
+	Interrupted     bool   `json:"interrupted,omitempty"`
+	InterruptReason string `json:"interrupt_reason,omitempty"`
+}
+
+type SkippedFile struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
 }
 
 var (
-	apiKey        string
-	cleanup       bool
-	dryRun        bool
-	output        string
-	vectorStoreID string
-	folder        string
+	apiKey               string
+	cleanup              bool
+	dryRun               bool
+	output               string
+	vectorStoreID        string
+	folder               string
+	concurrency          int
+	rampUp               time.Duration
+	stableWindow         time.Duration
+	hashAlgo             string
+	hashCachePath        string
+	purpose              string
+	purposeMap           string
+	purposes             map[string]string
+	quietNoChange        bool
+	logLevel             string
+	logFormat            string
+	multipartSize        int64
+	partSize             int64
+	normalizeEOL         bool
+	manifestID           string
+	teardown             bool
+	expiresSpec          string
+	expiresAfter         *ExpiresAfter
+	reportDupes          bool
+	reportFormat         string
+	noVectorStore        bool
+	force                bool
+	maxDeletePct         float64
+	sidecarSuffix        string
+	checkRemote          bool
+	tags                 = tagFlag{}
+	mergePaths           string
+	maxFiles             int
+	continueOnError      bool
+	outputTemplate       string
+	prune                bool
+	maxResponseBytes     int64
+	dryRunHTTP           bool
+	metricsFile          string
+	appendOnly           bool
+	adaptiveConcurrency  bool
+	dumpConfig           bool
+	excludeManifest      string
+	runTimeout           time.Duration
+	jsonIndentSpec       string
+	jsonIndent           = "  "
+	sortBy               string
+	caCerts              listFlag
+	clientCert           string
+	clientKey            string
+	onlyChanged          bool
+	remoteDiff           bool
+	rulesPath            string
+	watchMode            bool
+	watchInterval        time.Duration
+	remoteCachePath      string
+	remoteCacheTTL       time.Duration
+	concurrencyPerHost   int
+	expireOlderThan      time.Duration
+	eventsFormat         string
+	eventsPath           string
+	runPreflight         bool
+	configPath           string
+	profile              string
+	listFailedOnly       bool
+	retryFailedOnly      bool
+	mapFilePath          string
+	bufferSize           int
+	simulateLatency      time.Duration
+	caseInsensitivePaths bool
+	batchInput           bool
+	batchManifestPath    string
+	manifestStoreKind    string
+	validateJSON         bool
+	strictJSON           bool
+	createStoreName      string
+	extFormatMap         string
+	healthAddr           string
+	ignoreVCS            bool
+	ignoreDirs           string
+	traceHTTP            bool
+	largeFileSize        int64
+	maxLargeUploads      int
+	randSeed             uint64
+	compareRemoteOnly    bool
+	relativePaths        bool
+	verifyMembershipOnly bool
+	fixMembership        bool
+	dedupRemoteOnly      bool
+	validateFineTune     bool
+	singlePass           bool
+	skipFilePath         string
+	clearSkips           bool
+	manifestCompat       string
+	netrcPath            string
+	throttleErrorRate    float64
+	throttleWindow       int
+	throttleRecovery     int
+	newerThanManifest    bool
+	assumeYes            bool
+	checkOnly            bool
+	logFilePath          string
+	logMaxSize           int64
+	quiet                bool
+	stripPrefix          string
+	planOutput           string
+	reindexAllOnly       bool
+	waitForIndexing      bool
+	chunkSize            int
+	chunkOverlap         int
+	scope                string
+	fileIDPath           string
+	summaryOnly          bool
+
+	// runCtx carries the -run-timeout deadline and ends on SIGINT or SIGTERM; once it is
+	// done no new work is started
+	runCtx = context.Background()
 )
 
 func init() {
@@ -53,245 +246,1905 @@ func init() {
 	flag.BoolVar(&cleanup, "cleanup", false, "enable cleanup of deleted files in OpenAI")
 	flag.BoolVar(&dryRun, "dry-run", false, "disable uploading to OpenAI")
 	flag.StringVar(&output, "output", "", "output file for the manifest; if not specified, print to console")
-	flag.StringVar(&vectorStoreID, "vector-store-id", "", "ID of the OpenAI Vector Store")
+	flag.StringVar(&vectorStoreID, "vector-store-id", "", "ID of the OpenAI Vector Store (default: $OPENAI_VECTOR_STORE_ID)")
 	flag.StringVar(&folder, "folder", "./your-folder", "folder to scan for files")
+	flag.IntVar(&concurrency, "concurrency", 1, "number of files to upload in parallel")
+	flag.DurationVar(&rampUp, "ramp-up", time.Second, "maximum random delay before each upload worker starts; 0 disables the ramp")
+	flag.DurationVar(&stableWindow, "stable-window", 0, "skip files modified within this window, as they may still be being written")
+	flag.StringVar(&hashAlgo, "hash-algo", "sha256", "hash algorithm used to detect file changes (sha256, sha512, blake2b)")
+	flag.StringVar(&hashCachePath, "hash-cache", "", "file used to cache content hashes between runs, keyed by inode, size and mtime")
+	flag.StringVar(&purpose, "purpose", "assistants", "purpose of uploaded files")
+	flag.StringVar(&purposeMap, "purpose-map", "", "per-extension purpose overrides, e.g. pdf=assistants,csv=user_data")
+	flag.BoolVar(&quietNoChange, "quiet-no-changes", false, "print nothing and exit 0 when there is nothing to upload or clean up")
+	flag.StringVar(&logLevel, "log-level", "info", "log level (debug, info, warn, error)")
+	flag.StringVar(&logFormat, "log-format", "text", "log format (text, json)")
+	flag.Int64Var(&multipartSize, "multipart-threshold", 512<<20, "files of at least this many bytes are uploaded in parts through the Uploads API")
+	flag.Int64Var(&partSize, "part-size", 64<<20, "size in bytes of each part in a multipart upload")
+	flag.BoolVar(&normalizeEOL, "normalize-eol", false, "convert CRLF line endings to LF in text files before hashing and uploading")
+	flag.StringVar(&manifestID, "manifest-id", "", "stable manifest ID to use instead of one derived from the folder contents")
+	flag.BoolVar(&teardown, "delete-manifest-files-by-id", false, "delete every file recorded in the -output manifest from OpenAI and the vector store, without scanning the folder")
+	flag.StringVar(&expiresSpec, "expires-after", "", "expiration policy for uploaded files as <anchor>:<seconds>, e.g. created_at:86400")
+	flag.BoolVar(&reportDupes, "report-duplicates", false, "scan the folder, print groups of byte-identical files and exit")
+	flag.StringVar(&reportFormat, "format", "text", "output format for reports (text, json)")
+	flag.BoolVar(&noVectorStore, "no-vector-store", false, "upload files only, without adding them to or removing them from a vector store")
+	flag.BoolVar(&force, "force", false, "allow cleanup to delete more than -max-delete-percent of tracked files")
+	flag.Float64Var(&maxDeletePct, "max-delete-percent", 50, "abort cleanup that would delete more than this percentage of tracked files, unless -force is given")
+	flag.StringVar(&sidecarSuffix, "sidecar-suffix", "", "suffix of sidecar files holding vector store attributes for the file they accompany, e.g. .meta.json")
+	flag.BoolVar(&checkRemote, "check-remote", false, "confirm each recorded file ID still exists in OpenAI and re-upload files that are gone")
+	flag.Var(tags, "tag", "key=value annotation recorded in the manifest and logs; may be repeated")
+	flag.StringVar(&mergePaths, "merge", "", "comma-separated manifests to merge into -output (or stdout) instead of syncing")
+	flag.IntVar(&maxFiles, "max-files", 0, "process at most this many files, leaving the rest untracked; disables cleanup")
+	flag.BoolVar(&continueOnError, "continue-on-error", false, "record files that fail to upload and carry on with the rest instead of aborting")
+	flag.StringVar(&outputTemplate, "output-template", "{folder}.json", "manifest filename used when -output is a directory; supports {folder}, {date} and {manifest-id}")
+	flag.BoolVar(&prune, "prune-manifest", false, "drop entries for files no longer on disk from the -output manifest without calling OpenAI")
+	flag.Int64Var(&maxResponseBytes, "max-response-bytes", 10<<20, "maximum size of an API response body; larger responses are treated as errors")
+	flag.BoolVar(&dryRunHTTP, "dry-run-http", false, "log every API request instead of sending it; the manifest is printed rather than written")
+	flag.StringVar(&metricsFile, "metrics-file", "", "write run metrics in Prometheus text format to this file, e.g. for node_exporter's textfile collector")
+	flag.BoolVar(&appendOnly, "append-only", false, "only upload files missing from the manifest; tracked files are neither rehashed nor deleted")
+	flag.BoolVar(&adaptiveConcurrency, "adaptive-concurrency", false, "start at a concurrency of 1 and adapt up to -concurrency, backing off when rate limited")
+	flag.BoolVar(&dumpConfig, "dump-config", false, "print the resolved configuration as JSON and exit")
+	flag.StringVar(&excludeManifest, "exclude-manifest", "", "skip files whose path or hash is tracked by this other manifest")
+	flag.Var(&caCerts, "ca-cert", "PEM bundle of additional CAs to trust for API requests; may be repeated")
+	flag.StringVar(&clientCert, "client-cert", "", "PEM client certificate for mutual TLS; requires -client-key")
+	flag.StringVar(&clientKey, "client-key", "", "PEM private key for -client-cert")
+	flag.BoolVar(&onlyChanged, "only-changed", false, "print new, changed and removed files compared to the manifest (see -format) and exit without any API calls")
+	flag.BoolVar(&remoteDiff, "remote-diff", false, "in dry-run mode, also compare the manifest with the account's remote file list")
+	flag.StringVar(&rulesPath, "rules", "", "JSON file of rules routing files matching a glob to a purpose, vector store and attributes")
+	flag.BoolVar(&watchMode, "watch", false, "keep running and sync again whenever files in the folder change; implies -cleanup")
+	flag.DurationVar(&watchInterval, "watch-interval", 2*time.Second, "how long changes must settle under -watch before a sync starts")
+	flag.StringVar(&remoteCachePath, "remote-cache", "", "file caching the remote file list between runs, revalidated with If-None-Match")
+	flag.DurationVar(&remoteCacheTTL, "remote-cache-ttl", 0, "reuse the -remote-cache list without any request while it is younger than this")
+	flag.IntVar(&concurrencyPerHost, "concurrency-per-host", 0, "maximum connections to the API host, shared by all workers; 0 means one per worker")
+	flag.DurationVar(&expireOlderThan, "expire-older-than", 0, "delete tracked files uploaded longer ago than this from OpenAI and the manifest, then exit")
+	flag.StringVar(&eventsFormat, "events", "", "stream per-file progress events in this format (jsonl)")
+	flag.StringVar(&eventsPath, "events-file", "", "file or named pipe to write -events to (default: stdout)")
+	flag.BoolVar(&runPreflight, "preflight", false, "check the API key and vector stores without uploading anything, then exit")
+	flag.StringVar(&configPath, "config", "", "JSON file of option profiles; command-line flags and environment variables take precedence")
+	flag.StringVar(&profile, "profile", "", "profile of -config to apply on top of its default profile")
+	flag.BoolVar(&listFailedOnly, "list-failed", false, "print the manifest entries whose upload, indexing or deletion failed (see -format) and exit")
+	flag.BoolVar(&retryFailedOnly, "retry-failed-only", false, "retry only the manifest entries that failed or are not yet uploaded, without scanning the folder")
+	flag.StringVar(&mapFilePath, "map-file", "", "JSON file mapping local paths to the filenames they are uploaded under")
+	flag.IntVar(&bufferSize, "buffer-size", 32<<10, "size in bytes of the buffer each worker streams file content through when hashing and uploading")
+	flag.DurationVar(&simulateLatency, "simulate-latency", 0, "debug only: sleep this long before every API request")
+	flag.Uint64Var(&randSeed, "rand-seed", 0, "debug only: seed random delays so test runs repeat exactly; 0 leaves them unseeded")
+	flag.BoolVar(&caseInsensitivePaths, "case-insensitive-paths", false, "match files to manifest entries regardless of case, tracking paths that differ only by case once")
+	flag.BoolVar(&batchInput, "batch-input", false, "upload the folder's .jsonl files with purpose batch, recording them in -batch-manifest instead of syncing")
+	flag.StringVar(&batchManifestPath, "batch-manifest", "batch-manifest.json", "manifest of the file IDs uploaded by -batch-input")
+	flag.StringVar(&manifestStoreKind, "manifest-store", "file", "where manifests are saved: file (the -output file, or stdout without one) or stdout (read -output but never write it)")
+	flag.BoolVar(&validateJSON, "validate-json", false, "skip changed .json and .jsonl files that don't parse")
+	flag.BoolVar(&strictJSON, "strict-json", false, "abort the run, before any upload, if a file fails -validate-json or -validate-finetune")
+	flag.BoolVar(&validateFineTune, "validate-finetune", false, "skip changed fine-tune .jsonl files whose lines aren't chat or completion examples")
+	flag.StringVar(&createStoreName, "create-vector-store", "", "create a vector store with this name when none is configured; done even under -dry-run")
+	flag.StringVar(&extFormatMap, "ext-format", "", "per-extension \"format\" vector store attributes, e.g. md=markdown,pdf=pdf; defaults adds the built-in mapping, e.g. defaults,txt= (empty drops one)")
+	flag.StringVar(&healthAddr, "health-addr", "", "address such as :8080 serving /healthz and /readyz under -watch")
+	flag.BoolVar(&ignoreVCS, "ignore-vcs", false, "skip version control and build directories such as .git and node_modules; see -ignore-dirs")
+	flag.StringVar(&ignoreDirs, "ignore-dirs", defaultIgnoredDirs, "comma-separated directory names skipped by -ignore-vcs")
+	flag.BoolVar(&traceHTTP, "trace-http", false, "log DNS, connect, TLS and time-to-first-byte timings of each request at debug level")
+	flag.Int64Var(&largeFileSize, "large-file-size", 100<<20, "files of at least this many bytes count against -max-concurrent-large-files")
+	flag.IntVar(&maxLargeUploads, "max-concurrent-large-files", 0, "upload at most this many large files at once; 0 means no separate limit")
+	flag.BoolVar(&compareRemoteOnly, "compare-remote", false, "print how the -output manifest matches the account's files and vector stores, then exit without changes")
+	flag.Var(&explicitFiles, "files", "comma-separated files to sync besides -folder's, or instead of it when -folder isn't given; may be repeated")
+	flag.BoolVar(&relativePaths, "relative-paths", false, "record paths in the manifest relative to -folder, along with its absolute path as scan_root")
+	flag.BoolVar(&verifyMembershipOnly, "verify-vector-store-membership", false, "check that every file in the -output manifest is indexed in its vector store, then exit")
+	flag.BoolVar(&fixMembership, "fix", false, "with -verify-vector-store-membership, add files missing from their vector store again")
+	flag.BoolVar(&dedupRemoteOnly, "dedup-remote", false, "delete untracked remote copies of files the -output manifest tracks, after confirmation (see -assume-yes), then exit")
+	flag.BoolVar(&singlePass, "single-pass", false, "hash new files while uploading them, reading each once instead of twice")
+	flag.StringVar(&skipFilePath, "skip-file", "", "JSON file listing files that failed permanently, which are skipped instead of retried; new permanent failures are added")
+	flag.BoolVar(&clearSkips, "clear-skips", false, "empty the -skip-file before the run so every file is tried again")
+	flag.StringVar(&manifestCompat, "manifest-compat", "", "write the manifest in an older layout for existing consumers (1); empty for the current layout")
+	flag.StringVar(&netrcPath, "netrc", "", "netrc file holding the API key as the password for machine api.openai.com, or its default entry; takes precedence over OPENAI_API_KEY. Without it, ~/.netrc's api.openai.com entry is used only when OPENAI_API_KEY is unset")
+	flag.Float64Var(&throttleErrorRate, "throttle-on-error", 0, "halve concurrency when this fraction of recent requests fail with a network error, 5xx or 429 (e.g. 0.25); 0 disables")
+	flag.IntVar(&throttleWindow, "throttle-window", 20, "number of recent requests -throttle-on-error measures the error rate over")
+	flag.IntVar(&throttleRecovery, "throttle-recovery", 10, "consecutive successful requests after which -throttle-on-error doubles concurrency again")
+	flag.BoolVar(&newerThanManifest, "newer-than-manifest", false, "trust tracked files not modified since the previous run scanned them as unchanged instead of rehashing them")
+	flag.BoolVar(&assumeYes, "assume-yes", false, "confirm destructive actions without prompting, as needed when stdin is not a terminal")
+	flag.BoolVar(&assumeYes, "yes", false, "shorthand for -assume-yes")
+	flag.BoolVar(&checkOnly, "check", false, "dry run that lists pending uploads, attribute updates, reindexing and deletions, and exits with status 1 if there are any; the manifest is not written")
+	flag.StringVar(&logFilePath, "log-file", "", "also append logs, in the -log-format, to this file")
+	flag.Int64Var(&logMaxSize, "log-max-size", 0, "rotate the -log-file to <file>.1 once it would exceed this many bytes; 0 never rotates")
+	flag.BoolVar(&quiet, "quiet", false, "don't write logs to stderr; with -log-file they only go to the file")
+	flag.StringVar(&stripPrefix, "strip-prefix", "", "record paths in the manifest without this leading directory, e.g. /mnt/data; unlike -relative-paths it need not be -folder")
+	flag.StringVar(&planOutput, "plan-output", "", "with -dry-run, write the manifest the run would produce here, leaving -output untouched")
+	flag.BoolVar(&reindexAllOnly, "reindex-all", false, "remove every file in the -output manifest from its vector store and add it again with the current chunking and attributes, then exit")
+	flag.BoolVar(&waitForIndexing, "wait", false, "with -reindex-all, wait for each file's indexing to finish and report its final status")
+	flag.IntVar(&chunkSize, "chunk-size", 0, "maximum chunk size in tokens (100-4096) when adding files to a vector store; 0 leaves chunking to the API")
+	flag.IntVar(&chunkOverlap, "chunk-overlap", 0, "tokens of overlap between chunks with -chunk-size, at most half of it")
+	flag.StringVar(&scope, "scope", "", "subfolder of -folder, e.g. docs/api, to sync alone; entries elsewhere in the manifest are kept untouched")
+	flag.StringVar(&fileIDPath, "file-id-path", "", "dot-separated path to the file ID in upload responses, e.g. result.file.id, for OpenAI-compatible servers; by default id, data.id or data.0.id is used")
+	flag.BoolVar(&summaryOnly, "summary-only", false, "print the manifest's summary (file count, total bytes, corpus digest) instead of the full manifest when it would go to stdout")
+	flag.StringVar(&sortBy, "sort-by", "path", "order of files in the manifest (path, size, mtime)")
+	flag.StringVar(&jsonIndentSpec, "json-indent", "2", "manifest indentation: a number of spaces, tab, or 0 for compact single-line JSON")
+	flag.DurationVar(&runTimeout, "run-timeout", 0, "stop starting new work after this long, save the manifest and exit with status 3; 0 disables the limit")
+}
+
+// printConfig prints every option's effective value, after defaults and
+// environment fallbacks are applied, with the API key masked.
+func printConfig() {
+	config := map[string]interface{}{"openai_api_key": hideAPIKey(apiKey)}
+	flag.VisitAll(func(f *flag.Flag) {
+		if getter, ok := f.Value.(flag.Getter); ok {
+			config[f.Name] = getter.Get()
+		} else {
+			config[f.Name] = f.Value.String()
+		}
+	})
+	data, _ := json.MarshalIndent(config, "", "  ")
+	fmt.Println(string(data))
+}
+
+// tagFlag collects repeated -tag key=value flags.
+type tagFlag map[string]string
+
+func (t tagFlag) String() string {
+	var pairs []string
+	for key, value := range t {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (t tagFlag) Set(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("tag must be key=value: %q", value)
+	}
+	t[key] = val
+	return nil
 }
 
 func main() {
+	start := time.Now()
 	flag.Parse()
 
-	var manifest Manifest
+	if configPath != "" {
+		if err := applyConfig(configPath, profile); err != nil {
+			fmt.Println(err)
+			exit(2)
+		}
+	} else if profile != "" {
+		fmt.Println("-profile requires -config")
+		exit(2)
+	}
 
-	// Read existing manifest if available
-	if _, err := os.Stat(output); err == nil {
-		data, err := ioutil.ReadFile(output)
-		if err == nil {
-			json.Unmarshal(data, &manifest)
+	if err := setupLogger(logLevel, logFormat); err != nil {
+		fmt.Println(err)
+		exit(2)
+	}
+	defer logSink.close()
+	defer runExitHooks(0)
+	if len(tags) > 0 {
+		var attrs []any
+		for key, value := range tags {
+			attrs = append(attrs, key, value)
 		}
+		slog.SetDefault(slog.Default().With(slog.Group("tags", attrs...)))
+	}
+
+	resolveVectorStoreID()
+	if err := resolveAPIKey(); err != nil {
+		fmt.Println(err)
+		exit(2)
 	}
 
-	// Generate a new manifest ID if it doesn't exist
-	if manifest.ManifestID == "" {
-		manifest.ManifestID = generateManifestID(folder)
+	// Only -files are synced unless a folder is given too
+	parseExplicitFiles()
+	if len(explicitPaths) > 0 && !flagSet("folder") {
+		folder = ""
+	}
+
+	if planOutput != "" && !dryRun {
+		fmt.Println("-plan-output requires -dry-run")
+		exit(2)
+	}
+
+	if checkOnly {
+		if watchMode {
+			fmt.Println("-check cannot be combined with -watch")
+			exit(2)
+		}
+		dryRun = true
+	}
+
+	// A watched folder mirrors deletions as they happen
+	if watchMode {
+		cleanup = true
+		if stat, err := os.Stat(folder); folder != "" && err == nil && !stat.IsDir() {
+			fmt.Println("-watch requires -folder to be a directory")
+			exit(2)
+		}
+	}
+	if healthAddr != "" && !watchMode {
+		fmt.Println("-health-addr requires -watch")
+		exit(2)
+	}
+
+	if err := configureTransport(); err != nil {
+		fmt.Println(err)
+		exit(2)
+	}
+
+	// A capped run doesn't see every file, so it can't tell which ones were deleted
+	if maxFiles > 0 && cleanup {
+		slog.Warn("cleanup is disabled while -max-files is set", "max_files", maxFiles)
+		cleanup = false
+	}
+	if appendOnly && cleanup {
+		slog.Warn("cleanup is disabled in -append-only mode")
+		cleanup = false
+	}
+
+	if _, err := newHash(hashAlgo); err != nil {
+		fmt.Println(err)
+		exit(2)
+	}
+
+	if throttleErrorRate < 0 || throttleErrorRate > 1 {
+		fmt.Println("-throttle-on-error must be between 0 and 1")
+		exit(2)
+	}
+	if throttleWindow < 1 || throttleRecovery < 1 {
+		fmt.Println("-throttle-window and -throttle-recovery must be at least 1")
+		exit(2)
+	}
+
+	if stripPrefix != "" {
+		if relativePaths {
+			fmt.Println("-strip-prefix cannot be combined with -relative-paths")
+			exit(2)
+		}
+		var err error
+		if stripPrefix, err = filepath.Abs(stripPrefix); err != nil {
+			fmt.Println(err)
+			exit(2)
+		}
+
+		// Relative -files would be mistaken for stripped paths when the manifest is read back
+		for i, path := range explicitPaths {
+			if abs, err := filepath.Abs(path); err == nil {
+				delete(explicitPathSet, path)
+				explicitPaths[i], explicitPathSet[abs] = abs, true
+			}
+		}
+	}
+
+	if chunkSize != 0 && (chunkSize < 100 || chunkSize > 4096) {
+		fmt.Println("-chunk-size must be between 100 and 4096")
+		exit(2)
+	}
+	if chunkOverlap < 0 || chunkOverlap > 0 && chunkSize == 0 || chunkOverlap > chunkSize/2 {
+		fmt.Println("-chunk-overlap requires -chunk-size and must be at most half of it")
+		exit(2)
+	}
+
+	if fileIDPath != "" {
+		if err := checkFileIDPath(fileIDPath); err != nil {
+			fmt.Println(err)
+			exit(2)
+		}
 	}
 
+	if err := checkManifestCompat(manifestCompat); err != nil {
+		fmt.Println(err)
+		exit(2)
+	}
+
+	var err error
+	if purposes, err = parsePurposeMap(purposeMap); err != nil {
+		fmt.Println(err)
+		exit(2)
+	}
+	if randSeed != 0 {
+		seededRand = rand.New(rand.NewPCG(randSeed, randSeed))
+	}
+	if maxLargeUploads < 0 {
+		fmt.Println("-max-concurrent-large-files must not be negative")
+		exit(2)
+	} else if maxLargeUploads > 0 {
+		largeFileSlots = make(chan struct{}, maxLargeUploads)
+	}
+	if ignoreVCS {
+		ignoredDirs = parseIgnoredDirs(ignoreDirs)
+		slog.Info("ignoring directories", "names", strings.Join(slices.Sorted(maps.Keys(ignoredDirs)), ","))
+	}
+	if formats, err = parseFormatMap(extFormatMap); err != nil {
+		fmt.Println(err)
+		exit(2)
+	}
+	if expiresAfter, err = parseExpiresAfter(expiresSpec); err != nil {
+		fmt.Println(err)
+		exit(2)
+	}
+	if reportFormat != "text" && reportFormat != "json" {
+		fmt.Printf("invalid -format: %s\n", reportFormat)
+		exit(2)
+	}
+
+	// Strict validation needs validation
+	if strictJSON {
+		validateJSON = true
+	}
+
+	// These need every digest before anything is uploaded
+	if dryRun || reportDupes || onlyChanged || excludeManifest != "" {
+		singlePass = false
+	}
+
+	if manifestStoreKind != "file" && manifestStoreKind != "stdout" {
+		fmt.Printf("invalid -manifest-store: %s\n", manifestStoreKind)
+		exit(2)
+	}
+
+	if sortBy != "path" && sortBy != "size" && sortBy != "mtime" {
+		fmt.Printf("invalid -sort-by: %s\n", sortBy)
+		exit(2)
+	}
+
+	if bufferSize < 4096 {
+		fmt.Printf("invalid -buffer-size: %d, must be at least 4096\n", bufferSize)
+		exit(2)
+	}
+
+	if indent, err := parseJSONIndent(jsonIndentSpec); err != nil {
+		fmt.Println(err)
+		exit(2)
+	} else {
+		jsonIndent = indent
+	}
+
+	if eventsFormat != "" {
+		if eventsFormat != "jsonl" {
+			fmt.Printf("invalid -events: %s\n", eventsFormat)
+			exit(2)
+		}
+		var err error
+		if events, err = openEvents(eventsPath); err != nil {
+			fmt.Printf("Error opening -events-file: %v\n", err)
+			exit(2)
+		}
+	}
+
+	if err := validateOutputTemplate(outputTemplate); err != nil {
+		fmt.Println(err)
+		exit(2)
+	}
+	if stat, err := os.Stat(output); err == nil && stat.IsDir() {
+		id := manifestID
+		if id == "" && strings.Contains(outputTemplate, "{manifest-id}") {
+			if fsys, err := openFolder(folder); err == nil {
+				id = generateManifestID(fsys, folder)
+			}
+		}
+		output = filepath.Join(output, expandOutputTemplate(outputTemplate, id))
+	}
+
+	if dumpConfig {
+		printConfig()
+		return
+	}
+
+	if mergePaths != "" {
+		mergeManifests(strings.Split(mergePaths, ","))
+		return
+	}
+
+	// The first SIGINT or SIGTERM ends the run like a timeout, so in-flight work finishes
+	// and the manifest is saved; a second one kills the process. Every mode below
+	// runs under it, so -reindex-all -wait and the like stop too
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	signalCtx, interrupt := context.WithCancelCause(context.Background())
+	defer interrupt(nil)
+	go func() {
+		sig := <-signals
+		signal.Stop(signals)
+		interrupt(fmt.Errorf("received %s", sig))
+	}()
+	runCtx = signalCtx
+	if runTimeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeoutCause(signalCtx, runTimeout, fmt.Errorf("run timed out after %s", runTimeout))
+		defer cancel()
+	}
+
+	// Metrics are written however the run ends, so a failed or cut short run
+	// doesn't leave the previous run's numbers looking current
+	if metricsFile != "" {
+		onExit(func(code int) {
+			if err := writeMetrics(metricsFile, time.Since(start), code == 0); err != nil {
+				fmt.Printf("Error writing metrics file: %v\n", err)
+			}
+		})
+	}
+
+	// Read existing manifest if available. One that can't be read is not treated as
+	// missing, as the run would upload every file again and overwrite it
+	manifest, err := manifestStoreFor(output).Load()
+	if err != nil {
+		fmt.Printf("Error: load manifest %s: %v\n", output, err)
+		exit(1)
+	}
+
+	if dryRunHTTP {
+		httpClient.Transport = &dryRunTransport{}
+	}
+
+	if dedupRemoteOnly {
+		if output == "" {
+			fmt.Println("-dedup-remote requires -output pointing at the manifest")
+			exit(2)
+		}
+		dedupRemote(manifest)
+		return
+	}
+
+	if reindexAllOnly {
+		if output == "" {
+			fmt.Println("-reindex-all requires -output pointing at the manifest")
+			exit(2)
+		}
+		if !reindexAll(manifest) {
+			exit(1)
+		}
+		return
+	}
+
+	if verifyMembershipOnly {
+		if output == "" {
+			fmt.Println("-verify-vector-store-membership requires -output pointing at the manifest")
+			exit(2)
+		}
+		if !verifyMembership(manifest, fixMembership) {
+			exit(1)
+		}
+		return
+	}
+
+	if compareRemoteOnly {
+		if output == "" {
+			fmt.Println("-compare-remote requires -output pointing at the manifest")
+			exit(2)
+		}
+		if err := compareRemote(manifest); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			exit(1)
+		}
+		return
+	}
+
+	if listFailedOnly {
+		if output == "" {
+			fmt.Println("-list-failed requires -output pointing at the manifest")
+			exit(2)
+		}
+		listFailed(manifest)
+		return
+	}
+
+	if skipFilePath != "" {
+		var err error
+		if skips, err = loadSkipList(skipFilePath, clearSkips); err != nil {
+			fmt.Println(err)
+			exit(2)
+		}
+	}
+
+	if mapFilePath != "" {
+		var err error
+		if filenames, err = loadFilenameMap(mapFilePath); err != nil {
+			fmt.Println(err)
+			exit(2)
+		}
+	}
+
+	if rulesPath != "" {
+		var err error
+		if rules, err = loadRules(rulesPath); err != nil {
+			fmt.Println(err)
+			exit(2)
+		}
+	}
+
+	if runPreflight {
+		if !preflight() {
+			exit(1)
+		}
+		return
+	}
+
+	if teardown {
+		if output == "" {
+			fmt.Println("-delete-manifest-files-by-id requires -output pointing at the manifest")
+			exit(2)
+		}
+		deleteManifestFiles(manifest)
+		return
+	}
+
+	if prune {
+		if output == "" {
+			fmt.Println("-prune-manifest requires -output pointing at the manifest")
+			exit(2)
+		}
+		pruneManifest(manifest)
+		return
+	}
+
+	if expireOlderThan > 0 {
+		if output == "" {
+			fmt.Println("-expire-older-than requires -output pointing at the manifest")
+			exit(2)
+		}
+		expireOldFiles(manifest, time.Now().Add(-expireOlderThan))
+		return
+	}
+
+	// Responses are synthetic, so never persist the IDs they carry
+	if dryRunHTTP {
+		output = ""
+	}
+
+	// A missing folder is almost certainly a typo; scanning it would look like every file was deleted
+	fsys, err := openFolder(folder)
+	if err != nil {
+		fmt.Printf("Error: scan folder %s: %v\n", folder, err)
+		exit(1)
+	}
+	if scope != "" {
+		if err := checkScope(fsys); err != nil {
+			fmt.Println(err)
+			exit(2)
+		}
+	}
+
+	// Stripped paths are resolved against the prefix, so every path must be absolute,
+	// including those of a manifest written from a relative folder before
+	if stripPrefix != "" {
+		if folder != "" {
+			if folder, err = filepath.Abs(folder); err != nil {
+				fmt.Printf("Error: scan folder: %v\n", err)
+				exit(1)
+			}
+		}
+		absolutePaths(&manifest)
+		manifest.ScanRoot = ""
+	}
+
+	// A manifest with relative paths stays that way. Files are scanned under the absolute
+	// folder, which becomes the scan root that entries are resolved against
+	if (relativePaths || manifest.ScanRoot != "") && folder != "" {
+		if folder, err = filepath.Abs(folder); err != nil {
+			fmt.Printf("Error: scan folder: %v\n", err)
+			exit(1)
+		}
+		rebasePaths(&manifest, folder)
+		manifest.ScanRoot = folder
+	}
+
+	// Creating the store is the one write -dry-run allows, so a first preview shows a real store ID
+	if createStoreName != "" && vectorStoreID == "" {
+		if previous := manifest.LoggingInfo.VectorStoreID; previous != "" {
+			vectorStoreID = previous
+			slog.Info("using vector store created by an earlier run", "vector_store_id", vectorStoreID)
+		} else {
+			store, err := createVectorStore(createStoreName)
+			if err != nil {
+				fmt.Printf("Error creating vector store %s: %v\n", createStoreName, err)
+				exit(1)
+			}
+			vectorStoreID = store.ID
+			slog.Info("created vector store", "vector_store_id", store.ID, "name", createStoreName, "dry_run", dryRun)
+			fmt.Printf("Created vector store %s (%s)\n", store.ID, createStoreName)
+		}
+	}
+
+	// Use the requested manifest ID, or generate a new one if it doesn't exist
+	if manifestID != "" {
+		manifest.ManifestID = manifestID
+	} else if manifest.ManifestID == "" {
+		manifest.ManifestID = generateManifestID(fsys, folder)
+	}
+
+	if hashCachePath != "" {
+		cache = loadHashCache(hashCachePath)
+	}
+
+	if excludeManifest != "" {
+		excluded = loadExclusions(excludeManifest)
+	}
+
+	if batchInput {
+		runBatchInput(fsys, folder)
+		return
+	}
+
+	if reportDupes {
+		scannedManifest, _ := scanFolder(fsys, folder, manifest)
+		if cache != nil {
+			cache.save()
+		}
+		reportDuplicates(scannedManifest)
+		return
+	}
+
+	if onlyChanged {
+		scannedManifest, _ := scanFolder(fsys, folder, manifest)
+		if cache != nil {
+			cache.save()
+		}
+		reportChanges(scannedManifest, manifest)
+		return
+	}
+
+	if watchMode {
+		if healthAddr != "" {
+			serveHealth(healthAddr)
+		}
+		watch(fsys, folder, manifest)
+		return
+	}
+
+	if retryFailedOnly {
+		if output == "" {
+			fmt.Println("-retry-failed-only requires -output pointing at the manifest")
+			exit(2)
+		}
+		retryFailed(fsys, folder, manifest)
+		return
+	}
+
+	// A plan is written instead of the manifest, which the preview leaves untouched
+	if planOutput != "" {
+		output = planOutput
+	}
+	updatedManifest, err := Sync(fsys, folder, manifest)
+	if cache != nil {
+		cache.save()
+	}
+	var aborted *SyncAbortedError
+	if errors.As(err, &aborted) {
+		saveOrPrintManifest(updatedManifest, output)
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		exit(1)
+	}
+	if planOutput != "" {
+		updatedManifest = plannedManifest(updatedManifest)
+	}
+
+	// In-flight work has finished, so the manifest is consistent; save it and exit distinctly
+	if runCtx.Err() != nil {
+		saveOrPrintManifest(updatedManifest, output)
+		if errors.Is(runCtx.Err(), context.DeadlineExceeded) {
+			fmt.Printf("Run timed out after %s, remaining work left for the next run\n", runTimeout)
+			exit(3)
+		}
+		fmt.Printf("Run interrupted (%v), remaining work left for the next run\n", context.Cause(runCtx))
+		exit(130)
+	}
+
+	// A check reports what is out of date instead of the manifest, leaving it untouched
+	if checkOnly {
+		if reportOutOfDate(updatedManifest) > 0 {
+			exit(1)
+		}
+		return
+	}
+
+	// Stay silent for scheduled runs where nothing changed
+	if quietNoChange && updatedManifest.LoggingInfo.Changes == 0 {
+		if output != "" {
+			saveOrPrintManifest(updatedManifest, output)
+		}
+		return
+	}
+
+	// Save or print the updated manifest
+	saveOrPrintManifest(updatedManifest, output)
+}
+
+// Sync scans fsys, recording its files in the manifest under root, and brings
+// OpenAI in line with it: new and changed files are uploaded and, with
+// -cleanup, stale ones deleted. Nothing is changed remotely in dry-run mode.
+func Sync(fsys fs.FS, root string, manifest Manifest) (Manifest, error) {
 	// Scan the folder and update the manifest
-	updatedManifest := scanFolder(folder, manifest)
+	scanStarted := time.Now()
+	updatedManifest, skipped := scanFolder(fsys, root, manifest)
+	if checkRemote {
+		checkRemoteFiles(updatedManifest)
+	}
+	updatedManifest.PendingDeletes = staleFiles(updatedManifest, manifest)
+	if len(updatedManifest.Files) == 0 && len(skipped) == 0 && runCtx.Err() == nil {
+		slog.Warn("scan folder contains no files", "folder", root)
+	}
 
 	// Log configuration information
 	updatedManifest.LoggingInfo = LogInfo{
 		GeneratedAt:   time.Now().Format(time.RFC3339),
+		ScanStartedAt: scanStarted.Format(time.RFC3339),
 		OpenAIAPIKey:  hideAPIKey(apiKey),
-		ScanFolder:    folder,
+		ScanFolder:    root,
 		VectorStoreID: vectorStoreID,
 		Cleanup:       cleanup,
 		DryRun:        dryRun,
 		OutputFile:    output,
+		Skipped:       skipped,
+		Changes:       pendingChanges(updatedManifest),
+		Tags:          tags,
+		StripPrefix:   stripPrefix,
+	}
+
+	if dryRun && remoteDiff {
+		diff, err := diffRemote(updatedManifest)
+		if err != nil {
+			slog.Warn("could not compare with remote files", "error", err)
+		}
+		updatedManifest.LoggingInfo.RemoteDiff = diff
+	}
+
+	if strictJSON {
+		for _, skip := range skipped {
+			if strings.HasPrefix(skip.Reason, invalidJSONReason) || strings.HasPrefix(skip.Reason, invalidFineTuneReason) {
+				return updatedManifest, fmt.Errorf("%s: %s (-strict-json)", skip.Path, skip.Reason)
+			}
+		}
+	}
+
+	// Without -continue-on-error a file that couldn't be hashed fails the run
+	// before anything is uploaded, though the scan is still saved
+	if !continueOnError {
+		for _, skip := range skipped {
+			if strings.HasPrefix(skip.Reason, hashFailedReason) {
+				return updatedManifest, &SyncAbortedError{Path: skip.Path, Err: errors.New(skip.Reason)}
+			}
+		}
+	}
+
+	// Guard against mass deletion, e.g. from pointing at the wrong folder. Only
+	// files gone from the folder count; a changed file's old upload is replaced
+	if cleanup && !dryRun && !force {
+		tracked := 0
+		for _, fileInfo := range manifest.Files {
+			if fileInfo.FileID != "" {
+				tracked++
+			}
+		}
+		local := make(map[string]bool, len(updatedManifest.Files))
+		for _, fileInfo := range updatedManifest.Files {
+			local[fileInfo.Path] = true
+		}
+		deleting := 0
+		for _, fileInfo := range updatedManifest.PendingDeletes {
+			if !local[fileInfo.Path] {
+				deleting++
+			}
+		}
+		if tracked > 0 && float64(deleting)*100 > maxDeletePct*float64(tracked) &&
+			!confirmAction(fmt.Sprintf("delete %d of %d tracked files from OpenAI in cleanup, more than %.0f%%", deleting, tracked, maxDeletePct)) {
+			return updatedManifest, fmt.Errorf("cleanup would delete %d of %d tracked files, more than %.0f%%. Check -folder, or rerun with -force", deleting, tracked, maxDeletePct)
+		}
 	}
 
 	// Upload changed files to OpenAI if not in dry-run mode
 	if !dryRun {
-		for i, fileInfo := range updatedManifest.Files {
-			if fileInfo.FileID == "" {
-				fileID := uploadFile(fileInfo.Path, updatedManifest.ManifestID)
-				updatedManifest.Files[i].FileID = fileID
-				fmt.Printf("Uploaded %s, got FileID: %s\n", fileInfo.Path, fileID)
-
-				// Add/Update file in vector store
-				createVectorStoreFile(fileID)
-			}
+		failed, err := uploadChangedFiles(updatedManifest)
+		updatedManifest.LoggingInfo.Skipped = append(updatedManifest.LoggingInfo.Skipped, failed...)
+		if err != nil {
+			return updatedManifest, err
+		}
+
+		updated, failed, err := updateChangedAttributes(updatedManifest)
+		updatedManifest.LoggingInfo.AttributeUpdates = updated
+		updatedManifest.LoggingInfo.Skipped = append(updatedManifest.LoggingInfo.Skipped, failed...)
+		if err != nil {
+			return updatedManifest, err
 		}
 	}
 
 	// Perform cleanup if enabled and not in dry-run mode
 	if cleanup && !dryRun {
-		performCleanup(updatedManifest, manifest)
+		updatedManifest.PendingDeletes = performCleanup(updatedManifest.PendingDeletes)
 	}
 
-	// Save or print the updated manifest
-	saveOrPrintManifest(updatedManifest, output)
+	// A run cut short leaves work for the next one, so it mustn't be mistaken for a complete one
+	if runCtx.Err() != nil {
+		updatedManifest.LoggingInfo.Interrupted = true
+		updatedManifest.LoggingInfo.InterruptReason = context.Cause(runCtx).Error()
+	}
+
+	return updatedManifest, nil
 }
 
-func generateManifestID(folder string) string {
+// uploadChangedFiles uploads every file without a FileID. With
+// -continue-on-error, files that fail to upload are returned instead of
+// aborting the run, and stay pending for the next one.
+func uploadChangedFiles(manifest Manifest) ([]SkippedFile, error) {
+	var pending []int
+	for i, fileInfo := range manifest.Files {
+		if (fileInfo.FileID == "" || fileInfo.Status == "failed") && inScope(fileInfo.Path) {
+			pending = append(pending, i)
+		}
+	}
+	return uploadFiles(manifest, pending)
+}
+
+// uploadFiles uploads the manifest entries at the given indexes and adds them
+// to their vector store. Entries that were uploaded but failed to be indexed
+// are only indexed again. With -retry-failed-only, the manifest is saved
+// after every file. Without -continue-on-error, the first failure stops
+// further files from starting and is returned as a *SyncAbortedError.
+func uploadFiles(manifest Manifest, pending []int) ([]SkippedFile, error) {
+	var mu sync.Mutex
+	var failed []SkippedFile
+	var abort error
+	runPool(pending, func(i int) {
+		mu.Lock()
+		if abort != nil {
+			mu.Unlock()
+			return
+		}
+		fileInfo := manifest.Files[i]
+		mu.Unlock()
+
+		filePurpose := fileInfo.Purpose
+		if filePurpose == "" {
+			filePurpose = purposeFor(fileInfo.Path)
+		}
+		if retryFailedOnly && output != "" {
+			defer func() {
+				mu.Lock()
+				defer mu.Unlock()
+				saveOrPrintManifest(manifest, output)
+			}()
+		}
+
+		// Entries uploaded by an earlier run only need indexing again
+		fileID := fileInfo.FileID
+		stat, statErr := statPath(fileInfo.Path)
+		if fileID == "" {
+			if statErr == nil {
+				release := acquireLargeFileSlot(stat.Size())
+				defer release()
+			}
+			var err error
+			if statErr == nil && stat.Size() >= multipartSize {
+				// Persist upload progress after every part so an interrupted run can resume
+				fileID, err = uploadLargeFile(fileInfo, filePurpose, func(state *UploadState) {
+					snapshot := *state
+					snapshot.PartIDs = append([]string(nil), state.PartIDs...)
+
+					mu.Lock()
+					defer mu.Unlock()
+					manifest.Files[i].Upload = &snapshot
+					if output != "" {
+						saveOrPrintManifest(manifest, output)
+					}
+				})
+			} else {
+				// A file left unhashed by -single-pass is hashed as it uploads, and
+				// goes without an idempotency key, which is derived from the digest
+				var digest hash.Hash
+				key := ""
+				if fileInfo.SHA256 == "" {
+					digest, _ = newHash(fileInfo.hashAlgo())
+				} else {
+					key = idempotencyKey(manifest.ManifestID, fileInfo, filePurpose)
+					mu.Lock()
+					manifest.Files[i].IdempotencyKey = key
+					mu.Unlock()
+				}
+				fileID, err = uploadFile(fileInfo.Path, fileInfo.uploadName(), manifest.ManifestID, filePurpose, key, digest)
+				if err == nil && digest != nil {
+					mu.Lock()
+					manifest.Files[i].SHA256 = hex.EncodeToString(digest.Sum(nil))
+					mu.Unlock()
+				}
+			}
+			if err != nil {
+				if continueOnError {
+					fmt.Printf("Error uploading %s: %v\n", fileInfo.Path, err)
+				}
+				events.emit(Event{Type: "upload_failed", Path: fileInfo.Path, Error: err.Error()})
+				stats.failures.Add(1)
+				if permanentFailure(err) {
+					skips.add(fileInfo.Path, err.Error())
+				}
+				mu.Lock()
+				failed = append(failed, SkippedFile{Path: fileInfo.Path, Reason: err.Error()})
+				manifest.Files[i].Status, manifest.Files[i].Error = "failed", err.Error()
+				if !continueOnError && abort == nil {
+					abort = &SyncAbortedError{Path: fileInfo.Path, Err: err}
+				}
+				mu.Unlock()
+				return
+			}
+
+			mu.Lock()
+			manifest.Files[i].FileID = fileID
+			manifest.Files[i].UploadedAt = time.Now().UTC().Format(time.RFC3339)
+			manifest.Files[i].Purpose = filePurpose
+			manifest.Files[i].ExpiresAfter = expiresAfter
+			manifest.Files[i].Upload = nil
+			manifest.Files[i].Status, manifest.Files[i].Error = "", ""
+			mu.Unlock()
+			fmt.Printf("Uploaded %s, got FileID: %s\n", fileInfo.Path, fileID)
+			stats.filesUploaded.Add(1)
+			uploaded := Event{Type: "uploaded", Path: fileInfo.Path, FileID: fileID}
+			if statErr == nil {
+				stats.bytesUploaded.Add(stat.Size())
+				uploaded.Bytes = stat.Size()
+			}
+			events.emit(uploaded)
+		}
+
+		// Add/Update file in vector store
+		if store := storeFor(fileInfo); store != "" {
+			vsFile, err := createVectorStoreFile(store, fileID, fileInfo.Attributes)
+			if err != nil {
+				if continueOnError {
+					fmt.Printf("Error adding %s to vector store %s: %v\n", fileInfo.Path, store, err)
+				}
+				stats.failures.Add(1)
+				mu.Lock()
+				failed = append(failed, SkippedFile{Path: fileInfo.Path, Reason: err.Error()})
+				manifest.Files[i].Status, manifest.Files[i].Error = "failed", err.Error()
+				if !continueOnError && abort == nil {
+					abort = &SyncAbortedError{Path: fileInfo.Path, Err: fmt.Errorf("adding to vector store %s: %w", store, err)}
+				}
+				mu.Unlock()
+				return
+			}
+			mu.Lock()
+			manifest.Files[i].VectorStoreFileID = vsFile.ID
+			manifest.Files[i].VectorStoreStatus = vsFile.Status
+			manifest.Files[i].IndexedAt = time.Now().UTC().Format(time.RFC3339)
+			manifest.Files[i].Status, manifest.Files[i].Error = "", ""
+			mu.Unlock()
+			events.emit(Event{Type: "indexed", Path: fileInfo.Path, FileID: fileID, VectorStoreID: store})
+		}
+	})
+	return failed, abort
+}
+
+// SyncAbortedError reports the file whose failure stopped a run without
+// -continue-on-error. The files handled before it are recorded in the
+// manifest returned along with it, so that manifest is still saved.
+type SyncAbortedError struct {
+	Path string
+	Err  error
+}
+
+func (e *SyncAbortedError) Error() string {
+	// A file too large to upload is reported as such, with the way around it
+	var tooLarge *FileTooLargeError
+	if errors.As(e.Err, &tooLarge) {
+		return tooLarge.Error()
+	}
+	return fmt.Sprintf("%s: %v", e.Path, e.Err)
+}
+
+func (e *SyncAbortedError) Unwrap() error {
+	return e.Err
+}
+
+// resolveVectorStoreID falls back to OPENAI_VECTOR_STORE_ID when
+// -vector-store-id is not given on the command line.
+func resolveVectorStoreID() {
+	source := "flag"
+	if !flagSet("vector-store-id") {
+		source = "env"
+		vectorStoreID = os.Getenv("OPENAI_VECTOR_STORE_ID")
+		if vectorStoreID == "" {
+			source = "unset"
+		}
+	}
+	slog.Debug("resolved vector store", "vector_store_id", vectorStoreID, "source", source)
+}
+
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+func parsePurposeMap(spec string) (map[string]string, error) {
+	mapping := make(map[string]string)
+	if spec == "" {
+		return mapping, nil
+	}
+
+	for _, pair := range strings.Split(spec, ",") {
+		ext, p, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		ext = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), "."))
+		p = strings.TrimSpace(p)
+		if !ok || ext == "" || p == "" {
+			return nil, fmt.Errorf("invalid -purpose-map entry: %q", pair)
+		}
+		mapping[ext] = p
+	}
+	return mapping, nil
+}
+
+// purposeFor returns the upload purpose for a file, falling back to -purpose
+// when its extension has no -purpose-map entry.
+func purposeFor(path string) string {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+	if p, ok := purposes[ext]; ok {
+		return p
+	}
+	return purpose
+}
+
+func parseExpiresAfter(spec string) (*ExpiresAfter, error) {
+	if spec == "" {
+		return nil, nil
+	}
+
+	anchor, secs, ok := strings.Cut(spec, ":")
+	if !ok || anchor != "created_at" {
+		return nil, fmt.Errorf("invalid -expires-after %q: anchor must be created_at", spec)
+	}
+	seconds, err := strconv.ParseInt(secs, 10, 64)
+	if err != nil || seconds < 3600 || seconds > 2592000 {
+		return nil, fmt.Errorf("invalid -expires-after %q: seconds must be between 3600 (1 hour) and 2592000 (30 days)", spec)
+	}
+	return &ExpiresAfter{Anchor: anchor, Seconds: seconds}, nil
+}
+
+func generateManifestID(fsys fs.FS, root string) string {
 	hash := sha256.New()
-	filepath.Walk(folder, func(path string, info os.FileInfo, err error) error {
-		if !info.IsDir() {
-			hash.Write([]byte(path))
+	fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			hash.Write([]byte(filepath.Join(root, filepath.FromSlash(name))))
 		}
 		return nil
 	})
+	for _, path := range explicitPaths {
+		hash.Write([]byte(path))
+	}
 	return hex.EncodeToString(hash.Sum(nil))
 }
 
-func scanFolder(folder string, manifest Manifest) Manifest {
+// hashFailedReason starts the skip reason of files that could not be hashed,
+// such as one removed between being listed and read. Their previous entry is
+// kept as it was.
+const hashFailedReason = "could not hash"
+
+// scanFolder walks fsys and updates the manifest from its contents, recording
+// each file under root. fsys also becomes the source for later hashing and
+// uploads of those files.
+func scanFolder(fsys fs.FS, root string, manifest Manifest) (Manifest, []SkippedFile) {
+	contentFS, contentRoot = fsys, root
+	scopeRoot = ""
+	if scope != "" {
+		scopeRoot = manifestPath(scopeName())
+	}
+
+	// A manifest canonicalized once stays that way, so entries never flip between forms
+	foldCase := caseInsensitivePaths || manifest.CaseInsensitivePaths
+	pathKey := func(path string) string {
+		if foldCase {
+			return strings.ToLower(path)
+		}
+		return path
+	}
+
 	manifestMap := make(map[string]FileInfo)
 	for _, fileInfo := range manifest.Files {
-		manifestMap[fileInfo.Path] = fileInfo
+		manifestMap[pathKey(fileInfo.Path)] = fileInfo
+	}
+
+	var skipped []SkippedFile
+	seen := make(map[string]bool)
+	folded := make(map[string]string)
+	capped := false
+
+	var scannedAt time.Time
+	if newerThanManifest {
+		scannedAt = previousScanTime(manifest.LoggingInfo)
 	}
 
-	filepath.Walk(folder, func(path string, info os.FileInfo, err error) error {
-		if !info.IsDir() {
-			hash := hashFile(path)
-			if fileInfo, exists := manifestMap[path]; !exists || fileInfo.SHA256 != hash {
-				manifestMap[path] = FileInfo{Path: path, SHA256: hash, ManifestID: manifest.ManifestID}
+	// scanFile tracks one file, returning fs.SkipAll once the scan must stop
+	scanFile := func(path string, stat func() (fs.FileInfo, error)) error {
+		if maxFiles > 0 && len(seen) >= maxFiles || runCtx.Err() != nil {
+			capped = true
+			return fs.SkipAll
+		}
+
+		// Files that failed permanently before are left untracked rather than retried
+		if reason, ok := skips.reason(path); ok {
+			skipped = append(skipped, SkippedFile{Path: path, Reason: "listed in -skip-file: " + reason})
+			return nil
+		}
+
+		// Files handled by a sibling manifest are left untracked, so an entry
+		// this manifest already had becomes stale and is cleaned up
+		if excluded.hasPath(path) {
+			slog.Info("excluded by sibling manifest", "path", path, "match", "path")
+			return nil
+		}
+
+		// Paths differing only by case collide on case-insensitive filesystems
+		if other, ok := folded[strings.ToLower(path)]; ok {
+			slog.Warn("paths differ only by case", "path", path, "other", other)
+			if foldCase {
+				skipped = append(skipped, SkippedFile{Path: path, Reason: "differs only by case from " + other})
+				return nil
+			}
+		}
+		folded[strings.ToLower(path)] = path
+
+		info, err := stat()
+		if err == nil && info.Mode()&fs.ModeSymlink != 0 {
+			info, err = statPath(path)
+		}
+		if err != nil {
+			slog.Warn("could not stat file", "path", path, "error", err)
+			return nil
+		}
+
+		// Named pipes, devices and sockets would block or fail when read
+		if !info.Mode().IsRegular() {
+			kind := fileKind(info.Mode())
+			slog.Warn("skipping file that is not regular", "path", path, "kind", kind)
+			skipped = append(skipped, SkippedFile{Path: path, Reason: "not a regular file: " + kind})
+			return nil
+		}
+		key := pathKey(path)
+		seen[key] = true
+
+		// Defer recently modified files to the next run; any previous entry is kept as-is
+		if stableWindow > 0 && time.Since(info.ModTime()) < stableWindow {
+			reason := fmt.Sprintf("modified within stable window (%s)", stableWindow)
+			fmt.Printf("Skipping %s: %s\n", path, reason)
+			skipped = append(skipped, SkippedFile{Path: path, Reason: reason})
+			return nil
+		}
+
+		// Tracked files are trusted as immutable and not rehashed
+		if _, exists := manifestMap[key]; exists && appendOnly {
+			return nil
+		}
+
+		// Under -newer-than-manifest a tracked file untouched since the previous scan keeps
+		// its digest, and under -single-pass a new file is hashed while it uploads instead
+		hash := ""
+		prev, tracked := manifestMap[key]
+		if tracked && prev.SHA256 != "" && prev.hashAlgo() == hashAlgo && !scannedAt.IsZero() &&
+			!info.ModTime().After(scannedAt) && (prev.Bytes == 0 || prev.Bytes == info.Size()) {
+			hash = prev.SHA256
+		} else if !singlePass || tracked || info.Size() >= multipartSize {
+			if hash, err = hashFileProgress(path, hashAlgo, hashingProgress(path, info.Size())); err != nil {
+				slog.Warn("could not hash file", "path", path, "error", err)
+				skipped = append(skipped, SkippedFile{Path: path, Reason: hashFailedReason + ": " + err.Error()})
+				return nil
+			}
+			events.emit(Event{Type: "hashed", Path: path, Digest: hash, Bytes: info.Size()})
+		}
+		if hash != "" && excluded.hasDigest(hashAlgo, hash) {
+			slog.Info("excluded by sibling manifest", "path", path, "match", "hash")
+			delete(seen, key)
+			return nil
+		}
+		routed := route(FileInfo{Path: path}, loadSidecar(path))
+		fileInfo, exists := manifestMap[key]
+		fileInfo.Path = path // a canonicalized entry follows the file's current case
+		attrsDiffer := !reflect.DeepEqual(fileInfo.Attributes, routed.Attributes)
+		routeChanged := fileInfo.VectorStoreID != routed.VectorStoreID || fileInfo.Filename != routed.Filename ||
+			routed.Purpose != "" && fileInfo.Purpose != routed.Purpose
+		attrsChanged := attrsDiffer || fileInfo.Rule != routed.Rule || routeChanged
+
+		// An uploaded file whose attributes alone changed keeps its upload. Attributes only
+		// apply in a vector store, where they are updated in place; without one they are
+		// just recorded
+		sameContent := exists && fileInfo.hashAlgo() == hashAlgo && fileInfo.SHA256 == hash
+		if sameContent && attrsDiffer && !routeChanged && fileInfo.FileID != "" && fileInfo.Status == "" {
+			fileInfo.Attributes, fileInfo.Rule = routed.Attributes, routed.Rule
+			fileInfo.AttributesPending = fileInfo.AttributesPending || storeFor(fileInfo) != ""
+			fileInfo.Bytes = info.Size()
+			manifestMap[key] = fileInfo
+			return nil
+		}
+
+		// An entry hashed with a different algorithm is compared using its own algorithm,
+		// so switching -hash-algo rehashes files without re-uploading unchanged content
+		if exists && !attrsChanged && fileInfo.hashAlgo() != hashAlgo && hashMatches(path, fileInfo.hashAlgo(), fileInfo.SHA256) {
+			fileInfo.SHA256 = hash
+			fileInfo.HashAlgo = hashAlgo
+			fileInfo.Bytes = info.Size()
+			manifestMap[key] = fileInfo
+			return nil
+		}
+
+		if !exists || attrsChanged || fileInfo.hashAlgo() != hashAlgo || fileInfo.SHA256 != hash {
+			// Changed data files that don't parse are not uploaded; any previous entry is kept
+			if validateJSON {
+				if err := checkJSON(path); err != nil {
+					reason := fmt.Sprintf("%s: %v", invalidJSONReason, err)
+					fmt.Printf("Skipping %s: %s\n", path, reason)
+					skipped = append(skipped, SkippedFile{Path: path, Reason: reason})
+					return nil
+				}
+			}
+			if isVisionFile(routed) {
+				if mimeType, err := sniffContentType(path); err != nil || imageExtensions[mimeType] == nil {
+					reason := fmt.Sprintf("%s: %s", unsupportedImageReason, mimeType)
+					if err != nil {
+						reason = fmt.Sprintf("%s: %v", unsupportedImageReason, err)
+					}
+					fmt.Printf("Skipping %s: %s\n", path, reason)
+					skipped = append(skipped, SkippedFile{Path: path, Reason: reason})
+					return nil
+				}
 			}
+			if validateFineTune && isFineTuneFile(routed) {
+				if err := checkFineTune(path); err != nil {
+					reason := fmt.Sprintf("%s: %v", invalidFineTuneReason, err)
+					fmt.Printf("Skipping %s: %s\n", path, reason)
+					skipped = append(skipped, SkippedFile{Path: path, Reason: reason})
+					return nil
+				}
+			}
+			routed.SHA256 = hash
+			routed.HashAlgo = hashAlgo
+			routed.ManifestID = manifest.ManifestID
+			routed.NormalizedEOL = normalizeEOL && isTextFile(path)
+			routed.Bytes = info.Size()
+			manifestMap[key] = routed
+		} else {
+			fileInfo.Bytes = info.Size()
+			manifestMap[key] = fileInfo
 		}
 		return nil
-	})
+	}
+
+	walk := func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			slog.Warn("could not scan path", "path", manifestPath(name), "error", err)
+			return nil
+		}
+		if ignoredDir(name, d) {
+			slog.Debug("skipping ignored directory", "path", manifestPath(name))
+			return fs.SkipDir
+		}
+
+		path := manifestPath(name)
+		if !d.IsDir() && !isSidecar(path) {
+			return scanFile(path, d.Info)
+		}
+		return nil
+	}
+	if changedPaths == nil {
+		fs.WalkDir(fsys, scopeName(), walk)
+	} else {
+		// Under -watch only the changed paths are walked; removed ones are left out of the manifest
+		for _, changed := range changedPaths {
+			name := fsPath(changed)
+			if explicitPathSet[changed] || !fs.ValidPath(name) || !inScope(changed) || inIgnoredDir(name) {
+				continue
+			}
+			if _, err := fs.Stat(fsys, name); err == nil {
+				fs.WalkDir(fsys, name, walk)
+			}
+		}
+	}
+
+	// -files are tracked alongside the folder's files, unless the walk already reached them
+	for _, path := range explicitPaths {
+		if seen[pathKey(path)] || !inScope(path) || !watched(path) {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err == nil && info.IsDir() {
+			err = fmt.Errorf("is a directory")
+		}
+		if err != nil {
+			slog.Warn("skipping -files entry", "path", path, "error", err)
+			skipped = append(skipped, SkippedFile{Path: path, Reason: err.Error()})
+			continue
+		}
+		if scanFile(path, func() (fs.FileInfo, error) { return info, nil }) == fs.SkipAll {
+			break
+		}
+	}
 
+	// Entries for files no longer on disk are dropped so cleanup can delete them. A capped
+	// scan stops early, so entries it never reached are kept rather than treated as deleted
+	if capped && runCtx.Err() != nil {
+		slog.Warn("run timed out during scan, remaining files left untracked", "run_timeout", runTimeout)
+	} else if capped {
+		slog.Warn("file cap reached, remaining files left untracked", "max_files", maxFiles)
+	}
 	var files []FileInfo
-	for _, fileInfo := range manifestMap {
-		files = append(files, fileInfo)
+	for key, fileInfo := range manifestMap {
+		if seen[key] || capped || appendOnly || !inScope(fileInfo.Path) || !watched(fileInfo.Path) {
+			files = append(files, fileInfo)
+		}
+	}
+	sortFiles(files)
+
+	return Manifest{ManifestID: manifest.ManifestID, Files: files, CaseInsensitivePaths: foldCase, ReindexStartedAt: manifest.ReindexStartedAt, ScanRoot: manifest.ScanRoot, LoggingInfo: manifest.LoggingInfo}, skipped
+}
+
+// previousScanTime returns when the run that wrote a manifest began scanning,
+// or for manifests that predate scan_started_at, when it was generated. The
+// zero time, when neither is recorded, trusts no file.
+func previousScanTime(info LogInfo) time.Time {
+	value := info.ScanStartedAt
+	if value == "" {
+		value = info.GeneratedAt
 	}
+	if value == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		slog.Warn("-newer-than-manifest ignored: unreadable manifest timestamp", "value", value, "error", err)
+		return time.Time{}
+	}
+	return t
+}
+
+// fileKind describes a file that is not regular.
+func fileKind(mode fs.FileMode) string {
+	switch {
+	case mode.IsDir():
+		return "directory"
+	case mode&fs.ModeNamedPipe != 0:
+		return "named pipe"
+	case mode&fs.ModeSocket != 0:
+		return "socket"
+	case mode&fs.ModeDevice != 0:
+		return "device"
+	default:
+		return "irregular file"
+	}
+}
+
+// sortFiles orders files by -sort-by so the manifest diffs cleanly between
+// runs. Paths compare case-insensitively and break ties between equal keys.
+func sortFiles(files []FileInfo) {
+	keys := make(map[string]int64, len(files))
+	if sortBy != "path" {
+		for _, fileInfo := range files {
+			if info, err := statPath(fileInfo.Path); err == nil {
+				if sortBy == "size" {
+					keys[fileInfo.Path] = info.Size()
+				} else {
+					keys[fileInfo.Path] = info.ModTime().UnixNano()
+				}
+			}
+		}
+	}
+
+	slices.SortStableFunc(files, func(a, b FileInfo) int {
+		if c := cmp.Compare(keys[a.Path], keys[b.Path]); c != 0 {
+			return c
+		}
+		if c := strings.Compare(strings.ToLower(a.Path), strings.ToLower(b.Path)); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Path, b.Path)
+	})
+}
+
+func newHash(algo string) (hash.Hash, error) {
+	switch algo {
+	case "sha256":
+		return sha256.New(), nil
+	case "sha512":
+		return sha512.New(), nil
+	case "blake2b":
+		return blake2b.New256(nil)
+	default:
+		return nil, fmt.Errorf("unsupported hash algorithm: %s", algo)
+	}
+}
+
+// hashAlgo returns the algorithm the entry was hashed with; manifests written
+// before -hash-algo existed carry no name and are always SHA-256.
+func (f FileInfo) hashAlgo() string {
+	if f.HashAlgo == "" {
+		return "sha256"
+	}
+	return f.HashAlgo
+}
+
+func hashFile(filePath string, algo string) (string, error) {
+	return hashFileProgress(filePath, algo, nil)
+}
 
-	return Manifest{ManifestID: manifest.ManifestID, Files: files, LoggingInfo: manifest.LoggingInfo}
+// hashMatches reports whether the file hashes to digest with algo. A file
+// that can't be read doesn't match.
+func hashMatches(filePath, algo, digest string) bool {
+	hash, err := hashFile(filePath, algo)
+	return err == nil && hash == digest
 }
 
-func hashFile(filePath string) string {
-	file, err := os.Open(filePath)
+// hashFileProgress is hashFile reporting the bytes read so far to progress,
+// unless it is nil. Cached digests are returned without any progress calls.
+func hashFileProgress(filePath string, algo string, progress func(total int64)) (string, error) {
+	// Normalized content hashes differently, so it is cached separately
+	cacheAlgo := algo
+	if normalizeEOL {
+		cacheAlgo += "+eol"
+	}
+
+	var info fs.FileInfo
+	var err error
+	if cache != nil {
+		if info, err = statPath(filePath); err == nil {
+			if digest, ok := cache.lookup(info, cacheAlgo); ok {
+				return digest, nil
+			}
+		}
+	}
+
+	file, err := openContent(filePath)
 	if err != nil {
-		panic(err)
+		return "", err
 	}
 	defer file.Close()
 
-	hash := sha256.New()
-	if _, err := io.Copy(hash, file); err != nil {
-		panic(err)
+	hash, err := newHash(algo)
+	if err != nil {
+		return "", err
+	}
+	var r io.Reader = file
+	if progress != nil {
+		r = &progressReader{r: file, fn: progress}
+	}
+	// Hiding any WriterTo method makes the copy go through the -buffer-size buffer
+	if _, err := io.CopyBuffer(hash, struct{ io.Reader }{r}, make([]byte, bufferSize)); err != nil {
+		return "", err
 	}
 
-	return hex.EncodeToString(hash.Sum(nil))
+	digest := hex.EncodeToString(hash.Sum(nil))
+	if cache != nil && info != nil {
+		cache.store(info, cacheAlgo, digest)
+	}
+	return digest, nil
+}
+
+// FileTooLargeError reports a file the Files endpoint rejected as too large.
+type FileTooLargeError struct {
+	Path string
+	Size int64
 }
 
-func uploadFile(filePath string, manifestID string) string {
-	file, err := os.Open(filePath)
+func (e *FileTooLargeError) Error() string {
+	return fmt.Sprintf("%s is too large to upload (%d bytes); upload it in parts through the Uploads API by setting -multipart-threshold below its size", e.Path, e.Size)
+}
+
+// uploadFile uploads a file through the Files endpoint. When digest is not
+// nil, the uploaded content is written to it as well.
+func uploadFile(filePath string, filename string, manifestID string, purpose string, idempotencyKey string, digest io.Writer) (string, error) {
+	file, err := openContent(filePath)
 	if err != nil {
-		panic(err)
+		return "", err
 	}
 	defer file.Close()
 
-	stat, _ := file.Stat()
-	data := make([]byte, stat.Size())
-	file.Read(data)
+	var content io.Reader = file
+	var hashing *hashingReader
+	if digest != nil {
+		hashing = &hashingReader{r: file, h: digest}
+		content = hashing
+	}
+
+	// Normalized content differs in size from the file, so it is sent chunked
+	size := int64(-1)
+	if stat, err := statPath(filePath); err == nil && !(normalizeEOL && isTextFile(filePath)) {
+		size = stat.Size()
+	}
+
+	// Uploads already under way are finished rather than cut off when the run ends
+	fileID, err := uploadContent(context.Background(), filename, content, size, purpose, manifestID, idempotencyKey)
+	var tooLarge *FileTooLargeError
+	var rejected *UploadRejectedError
+	switch {
+	case errors.As(err, &tooLarge):
+		tooLarge.Path = filePath
+		if stat, err := statPath(filePath); err == nil {
+			tooLarge.Size = stat.Size()
+		}
+	case errors.As(err, &rejected):
+		rejected.Path = filePath
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if hashing != nil {
+		if err := hashing.finish(); err != nil {
+			return "", err
+		}
+	}
+	return fileID, nil
+}
+
+// UploadContent uploads the content read from r through the Files endpoint
+// under the given display name, returning the new file's ID. It lets programs
+// embedding the package upload generated documents that aren't on disk. The
+// content is streamed, so its length needn't be known in advance.
+func UploadContent(ctx context.Context, name string, r io.Reader, purpose string) (string, error) {
+	return uploadContent(ctx, name, r, -1, purpose, "", "")
+}
 
+// uploadContent sends an upload of size bytes, or of unknown size when
+// negative, tagged with manifestID and idempotencyKey when they are set.
+func uploadContent(ctx context.Context, name string, r io.Reader, size int64, purpose, manifestID, idempotencyKey string) (string, error) {
 	uploadURL := "https://api.openai.com/v1/files"
-	values := map[string]string{"purpose": "manifest"}
-	valuesJSON, _ := json.Marshal(values)
 
-	body := bytes.NewReader(data)
-	req, _ := http.NewRequest("POST", uploadURL, body)
+	fields := [][2]string{{"purpose", purpose}}
+	if expiresAfter != nil {
+		fields = append(fields,
+			[2]string{"expires_after[anchor]", expiresAfter.Anchor},
+			[2]string{"expires_after[seconds]", strconv.FormatInt(expiresAfter.Seconds, 10)},
+		)
+	}
+
+	// Images for vision are sent with their detected type, and a name to match
+	mimeType := ""
+	if purpose == "vision" {
+		head := make([]byte, 512)
+		n, err := io.ReadFull(r, head)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return "", err
+		}
+		mimeType = http.DetectContentType(head[:n])
+		name = imageName(name, mimeType)
+		r = io.MultiReader(bytes.NewReader(head[:n]), r)
+	}
+
+	body, contentType, length, err := multipartBody(fields, "file", name, mimeType, r, size)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", uploadURL, body)
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = length
 	req.Header.Set("Authorization", "Bearer "+apiKey)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("OpenAI-Manifest-ID", manifestID)
+	req.Header.Set("Content-Type", contentType)
+	if manifestID != "" {
+		req.Header.Set("OpenAI-Manifest-ID", manifestID)
+	}
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
 
-	client := http.Client{}
-	resp, err := client.Do(req)
+	resp, err := doRequest(req)
 	if err != nil {
-		panic(err)
+		return "", err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusRequestEntityTooLarge {
+		return "", &FileTooLargeError{Path: name, Size: size}
+	}
+	if resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusUnsupportedMediaType {
+		respBody, _ := readBody(resp)
+		return "", newUploadRejectedError(name, resp, respBody)
+	}
 	if resp.StatusCode != http.StatusOK {
-		respBody, _ := ioutil.ReadAll(resp.Body)
+		respBody, _ := readBody(resp)
 		fmt.Printf("Error uploading file: %s\n", string(respBody))
-		panic(fmt.Sprintf("Non-OK HTTP status: %s", resp.Status))
+		return "", fmt.Errorf("Non-OK HTTP status: %s", resp.Status)
+	}
+
+	respBody, err := readBody(resp)
+	if err != nil {
+		return "", err
+	}
+	fileID, err := extractFileID(respBody)
+	if err != nil {
+		return "", fmt.Errorf("upload of %s returned no file ID: %w: %s", name, err, string(respBody))
+	}
+	return fileID, nil
+}
+
+// idempotencyKey identifies an upload of a file's content under a manifest,
+// so retrying it sends the same key. The name and purpose are included as
+// they change what is created.
+func idempotencyKey(manifestID string, fileInfo FileInfo, purpose string) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{manifestID, fileInfo.hashAlgo(), fileInfo.SHA256, fileInfo.uploadName(), purpose}, "\n")))
+	return hex.EncodeToString(sum[:16])
+}
+
+// errNotFound is returned when the remote object is already gone.
+var errNotFound = errors.New("not found")
+
+// checkRemoteFiles clears the FileID of entries whose file no longer exists
+// in OpenAI, so they are uploaded again.
+func checkRemoteFiles(manifest Manifest) {
+	var tracked []int
+	for i, fileInfo := range manifest.Files {
+		if fileInfo.FileID != "" {
+			tracked = append(tracked, i)
+		}
+	}
+
+	runPool(tracked, func(i int) {
+		fileInfo := manifest.Files[i]
+		_, err := getFile(fileInfo.FileID)
+		if errors.Is(err, errNotFound) {
+			fmt.Printf("FileID %s for %s no longer exists, will re-upload\n", fileInfo.FileID, fileInfo.Path)
+			manifest.Files[i].FileID = ""
+		} else if err != nil {
+			slog.Warn("could not check remote file", "file_id", fileInfo.FileID, "error", err)
+		}
+	})
+}
+
+func getFile(fileID string) (RemoteFile, error) {
+	var file RemoteFile
+	req, err := http.NewRequest("GET", "https://api.openai.com/v1/files/"+fileID, nil)
+	if err != nil {
+		return file, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	resp, err := doRequest(req)
+	if err != nil {
+		return file, err
 	}
+	defer resp.Body.Close()
 
-	respBody, _ := ioutil.ReadAll(resp.Body)
-	var result map[string]interface{}
-	json.Unmarshal(respBody, &result)
+	if resp.StatusCode == http.StatusNotFound {
+		return file, fmt.Errorf("file %s: %w", fileID, errNotFound)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return file, fmt.Errorf("Non-OK HTTP status: %s", resp.Status)
+	}
 
-	return result["id"].(string)
+	respBody, err := readBody(resp)
+	if err != nil {
+		return file, err
+	}
+	err = json.Unmarshal(respBody, &file)
+	return file, err
 }
 
-func deleteFile(fileID string) {
-	client := &http.Client{}
+func deleteFile(fileID string) error {
 	req, err := http.NewRequest("DELETE", "https://api.openai.com/v1/files/"+fileID, nil)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	req.Header.Set("Authorization", "Bearer "+apiKey)
-	resp, err := client.Do(req)
+	resp, err := doRequest(req)
 	if err != nil {
-		panic(err)
+		return err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("file %s: %w", fileID, errNotFound)
+	}
 	if resp.StatusCode != http.StatusOK {
-		respBody, _ := ioutil.ReadAll(resp.Body)
+		respBody, _ := readBody(resp)
 		fmt.Printf("Error deleting file: %s\n", string(respBody))
-		panic(fmt.Sprintf("Non-OK HTTP status: %s", resp.Status))
+		return fmt.Errorf("Non-OK HTTP status: %s", resp.Status)
 	}
+	return nil
 }
 
-func createVectorStoreFile(fileID string) {
-	url := fmt.Sprintf("https://api.openai.com/v1/vector_stores/%s/files", vectorStoreID)
-	values := map[string]string{"file_id": fileID}
+// VectorStoreFile is the vector store's record of an added file.
+type VectorStoreFile struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+}
+
+// createVectorStoreFile adds an uploaded file to a vector store. Beyond the
+// status code, the returned object must reference the file, so an OK
+// response carrying an error payload is not mistaken for success.
+func createVectorStoreFile(storeID, fileID string, attributes map[string]interface{}) (VectorStoreFile, error) {
+	var vsFile VectorStoreFile
+	url := fmt.Sprintf("https://api.openai.com/v1/vector_stores/%s/files", storeID)
+	values := map[string]interface{}{"file_id": fileID}
+	if len(attributes) > 0 {
+		values["attributes"] = attributes
+	}
+	if chunkSize > 0 {
+		values["chunking_strategy"] = map[string]interface{}{
+			"type":   "static",
+			"static": map[string]int{"max_chunk_size_tokens": chunkSize, "chunk_overlap_tokens": chunkOverlap},
+		}
+	}
 	valuesJSON, _ := json.Marshal(values)
 
 	req, _ := http.NewRequest("POST", url, bytes.NewReader(valuesJSON))
 	req.Header.Set("Authorization", "Bearer "+apiKey)
 	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := doRequest(req)
 	if err != nil {
-		panic(err)
+		return vsFile, err
 	}
 	defer resp.Body.Close()
 
+	respBody, err := readBody(resp)
+	if err != nil {
+		return vsFile, err
+	}
 	if resp.StatusCode != http.StatusOK {
-		respBody, _ := ioutil.ReadAll(resp.Body)
 		fmt.Printf("Error creating vector store file: %s\n", string(respBody))
-		panic(fmt.Sprintf("Non-OK HTTP status: %s", resp.Status))
+		return vsFile, fmt.Errorf("Non-OK HTTP status: %s", resp.Status)
+	}
+	if err := json.Unmarshal(respBody, &vsFile); err != nil {
+		return vsFile, fmt.Errorf("parsing vector store file: %w", err)
+	}
+	if vsFile.ID != fileID {
+		return vsFile, fmt.Errorf("vector store file for %s has unexpected id %q: %s", fileID, vsFile.ID, string(respBody))
 	}
+	return vsFile, nil
 }
 
-func removeFromVectorStore(fileID string) {
-	url := fmt.Sprintf("https://api.openai.com/v1/vector_stores/%s/files/%s", vectorStoreID, fileID)
+func removeFromVectorStore(storeID, fileID string) error {
+	url := fmt.Sprintf("https://api.openai.com/v1/vector_stores/%s/files/%s", storeID, fileID)
 
 	req, _ := http.NewRequest("DELETE", url, nil)
 	req.Header.Set("Authorization", "Bearer "+apiKey)
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := doRequest(req)
 	if err != nil {
-		panic(err)
+		return err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("vector store file %s: %w", fileID, errNotFound)
+	}
 	if resp.StatusCode != http.StatusOK {
-		respBody, _ := ioutil.ReadAll(resp.Body)
+		respBody, _ := readBody(resp)
 		fmt.Printf("Error removing vector store file: %s\n", string(respBody))
-		panic(fmt.Sprintf("Non-OK HTTP status: %s", resp.Status))
+		return fmt.Errorf("Non-OK HTTP status: %s", resp.Status)
 	}
+	return nil
 }
 
-func performCleanup(updatedManifest, oldManifest Manifest) {
+// staleFiles returns the uploaded files of the old manifest, including those
+// it still had pending deletion, that are no longer referenced by the updated
+// one because they were removed or changed.
+func staleFiles(updatedManifest, oldManifest Manifest) []FileInfo {
 	fileMap := make(map[string]FileInfo)
 	for _, fileInfo := range updatedManifest.Files {
 		fileMap[fileInfo.FileID] = fileInfo
 	}
 
-	for _, fileInfo := range oldManifest.Files {
-		if _, exists := fileMap[fileInfo.FileID]; !exists {
-			// File no longer exists, so delete it
-			deleteFile(fileInfo.FileID)
-			fmt.Printf("Deleted FileID: %s\n", fileInfo.FileID)
+	var stale []FileInfo
+	for _, fileInfo := range slices.Concat(oldManifest.Files, oldManifest.PendingDeletes) {
+		if _, exists := fileMap[fileInfo.FileID]; !exists && fileInfo.FileID != "" {
+			stale = append(stale, fileInfo)
+		}
+	}
+	return stale
+}
 
-			// Remove file from vector store
-			removeFromVectorStore(fileInfo.FileID)
+// pendingChanges counts the uploads, reindexing of failed files, attribute
+// updates and, when cleanup is enabled, deletions a run would perform.
+func pendingChanges(manifest Manifest) int {
+	count := 0
+	for _, fileInfo := range manifest.Files {
+		if fileInfo.FileID == "" || fileInfo.Status == "failed" || fileInfo.AttributesPending {
+			count++
 		}
 	}
+	if cleanup {
+		count += len(manifest.PendingDeletes)
+	}
+	return count
 }
 
-func saveOrPrintManifest(manifest Manifest, outputPath string) {
-	data, _ := json.MarshalIndent(manifest, "", "  ")
+// performCleanup deletes stale files from the vector store and OpenAI in
+// parallel, returning those that could not be deleted so they stay pending
+// for the next run. Objects that are already gone count as deleted.
+func performCleanup(stale []FileInfo) []FileInfo {
+	jobs := make([]int, len(stale))
+	for i := range jobs {
+		jobs[i] = i
+	}
 
-	if outputPath == "" {
-		fmt.Println(string(data))
-	} else {
-		ioutil.WriteFile(outputPath, data, 0644)
+	var mu sync.Mutex
+	var failed []FileInfo
+	done := make([]bool, len(stale))
+	runPool(jobs, func(i int) {
+		fileInfo := stale[i]
+		defer func() {
+			mu.Lock()
+			done[i] = true
+			mu.Unlock()
+		}()
+
+		// Remove file from vector store, then delete the file itself
+		var err error
+		if store := storeFor(fileInfo); store != "" {
+			err = removeFromVectorStore(store, fileInfo.FileID)
+		}
+		if err == nil || errors.Is(err, errNotFound) {
+			if err = deleteFile(fileInfo.FileID); errors.Is(err, errNotFound) {
+				err = nil
+			}
+		}
+
+		if err != nil {
+			fmt.Printf("Error deleting FileID %s: %v\n", fileInfo.FileID, err)
+			events.emit(Event{Type: "delete_failed", Path: fileInfo.Path, FileID: fileInfo.FileID, Error: err.Error()})
+			stats.failures.Add(1)
+			fileInfo.Status, fileInfo.Error = "failed", err.Error()
+			mu.Lock()
+			failed = append(failed, fileInfo)
+			mu.Unlock()
+			return
+		}
+		fmt.Printf("Deleted FileID: %s\n", fileInfo.FileID)
+		events.emit(Event{Type: "deleted", Path: fileInfo.Path, FileID: fileInfo.FileID})
+		stats.filesDeleted.Add(1)
+	})
+
+	// Deletions never started before -run-timeout stay pending as well
+	for i, fileInfo := range stale {
+		if !done[i] {
+			failed = append(failed, fileInfo)
+		}
+	}
+	return failed
+}
+
+var templateToken = regexp.MustCompile(`\{[^{}]*\}`)
+
+func validateOutputTemplate(template string) error {
+	for _, token := range templateToken.FindAllString(template, -1) {
+		switch token {
+		case "{folder}", "{date}", "{manifest-id}":
+		default:
+			return fmt.Errorf("unknown token %s in -output-template", token)
+		}
+	}
+	return nil
+}
+
+func expandOutputTemplate(template string, id string) string {
+	return strings.NewReplacer(
+		"{folder}", filepath.Base(filepath.Clean(folder)),
+		"{date}", time.Now().Format("2006-01-02"),
+		"{manifest-id}", id,
+	).Replace(template)
+}
+
+func loadManifest(path string) (Manifest, error) {
+	var manifest Manifest
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return manifest, err
+	}
+	return unmarshalManifest(data)
+}
+
+// parseJSONIndent turns a -json-indent value into the indent string used for
+// manifests; an empty indent means compact output.
+func parseJSONIndent(spec string) (string, error) {
+	if spec == "tab" {
+		return "\t", nil
+	}
+	n, err := strconv.Atoi(spec)
+	if err != nil || n < 0 || n > 8 {
+		return "", fmt.Errorf("invalid -json-indent %q: expected 0-8 or tab", spec)
+	}
+	return strings.Repeat(" ", n), nil
+}
+
+func saveOrPrintManifest(manifest Manifest, outputPath string) {
+	if err := manifestStoreFor(outputPath).Save(manifest); err != nil {
+		fmt.Printf("Error saving manifest %s: %v\n", outputPath, err)
 	}
 }
 
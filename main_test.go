@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUploadContent(t *testing.T) {
+	saved := apiKey
+	apiKey = "sk-test"
+	t.Cleanup(func() { apiKey = saved })
+
+	content := []byte("generated report\nwith two lines\n")
+	serveAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/v1/files" {
+			t.Errorf("got %s %s, want POST /v1/files", r.Method, r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer "+apiKey {
+			t.Errorf("Authorization = %q", got)
+		}
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if got := r.FormValue("purpose"); got != "assistants" {
+			t.Errorf("purpose = %q, want assistants", got)
+		}
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		if header.Filename != "report.txt" {
+			t.Errorf("filename = %q, want report.txt", header.Filename)
+		}
+		if got, _ := io.ReadAll(file); !bytes.Equal(got, content) {
+			t.Errorf("content = %q, want %q", got, content)
+		}
+		w.Write([]byte(`{"id":"file-abc","object":"file"}`))
+	})
+
+	fileID, err := UploadContent(context.Background(), "report.txt", bytes.NewReader(content), "assistants")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileID != "file-abc" {
+		t.Errorf("file ID = %q, want file-abc", fileID)
+	}
+}
+
+func TestUploadContentError(t *testing.T) {
+	serveAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":{"message":"server error"}}`, http.StatusInternalServerError)
+	})
+
+	if _, err := UploadContent(context.Background(), "report.txt", bytes.NewReader([]byte("x")), "assistants"); err == nil {
+		t.Error("expected an error for a 500 response")
+	}
+}
+
+func TestUploadFilesTooLargeAborts(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	savedFS, savedRoot, savedKey, savedMultipart := contentFS, contentRoot, apiKey, multipartSize
+	contentFS, contentRoot, apiKey, multipartSize = os.DirFS(dir), dir, "sk-test", 1<<20
+	t.Cleanup(func() { contentFS, contentRoot, apiKey, multipartSize = savedFS, savedRoot, savedKey, savedMultipart })
+
+	requests := 0
+	serveAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.Error(w, `{"error":{"message":"too large"}}`, http.StatusRequestEntityTooLarge)
+	})
+
+	manifest := Manifest{Files: []FileInfo{
+		{Path: filepath.Join(dir, "a.txt"), SHA256: "a"},
+		{Path: filepath.Join(dir, "b.txt"), SHA256: "b"},
+	}}
+	_, err := uploadFiles(manifest, []int{0, 1})
+
+	var aborted *SyncAbortedError
+	if !errors.As(err, &aborted) {
+		t.Fatalf("error = %v, want a *SyncAbortedError", err)
+	}
+	if want := "a.txt is too large to upload"; !strings.Contains(err.Error(), want) || strings.HasPrefix(err.Error(), aborted.Path+": ") {
+		t.Errorf("error = %q, want the too large message", err)
+	}
+	if requests != 1 {
+		t.Errorf("%d upload requests, want 1 before aborting", requests)
+	}
+	if got := manifest.Files[0].Status; got != "failed" {
+		t.Errorf("status of a.txt = %q, want failed", got)
+	}
+	if got := manifest.Files[1].Status; got != "" {
+		t.Errorf("status of b.txt = %q, want it left pending", got)
+	}
+}
+
+func TestHashFileMissing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gone.txt")
+	explicitPathSet = map[string]bool{path: true}
+	t.Cleanup(func() { explicitPathSet = nil })
+
+	if _, err := hashFile(path, "sha256"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("error = %v, want one for a missing file", err)
+	}
+	if hashMatches(path, "sha256", "") {
+		t.Error("a missing file matched a digest")
+	}
+}
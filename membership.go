@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+type MembershipGap struct {
+	Path          string `json:"path"`
+	FileID        string `json:"file_id"`
+	VectorStoreID string `json:"vector_store_id"`
+	Problem       string `json:"problem"` // "missing", or "failed" when indexing failed
+	Fixed         bool   `json:"fixed,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+// verifyMembership checks that every uploaded file in the manifest is a
+// member of its vector store, listing each store once however large it is.
+// With fix, missing files are added again, and files whose indexing failed
+// are removed and added again; the manifest is then saved.
+func verifyMembership(manifest Manifest, fix bool) (ok bool) {
+	byStore := make(map[string][]int)
+	for i, fileInfo := range manifest.Files {
+		if store := storeFor(fileInfo); store != "" && fileInfo.FileID != "" {
+			byStore[store] = append(byStore[store], i)
+		}
+	}
+	stores := make([]string, 0, len(byStore))
+	for store := range byStore {
+		stores = append(stores, store)
+	}
+	sort.Strings(stores)
+
+	gaps := []MembershipGap{}
+	checked := 0
+	for _, store := range stores {
+		members, err := listVectorStoreFiles(store)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return false
+		}
+		status := make(map[string]string, len(members))
+		for _, member := range members {
+			status[member.ID] = member.Status
+		}
+
+		for _, i := range byStore[store] {
+			fileInfo := manifest.Files[i]
+			checked++
+			problem := ""
+			switch s, member := status[fileInfo.FileID]; {
+			case !member:
+				problem = "missing"
+			case s == "failed":
+				problem = "failed"
+			default:
+				continue
+			}
+			gap := MembershipGap{Path: fileInfo.Path, FileID: fileInfo.FileID, VectorStoreID: store, Problem: problem}
+
+			if fix {
+				var err error
+				if problem == "failed" {
+					err = removeFromVectorStore(store, fileInfo.FileID)
+				}
+				var vsFile VectorStoreFile
+				if err == nil {
+					vsFile, err = createVectorStoreFile(store, fileInfo.FileID, fileInfo.Attributes)
+				}
+				if err != nil {
+					gap.Error = err.Error()
+				} else {
+					gap.Fixed = true
+					manifest.Files[i].VectorStoreFileID = vsFile.ID
+					manifest.Files[i].VectorStoreStatus = vsFile.Status
+					manifest.Files[i].Status, manifest.Files[i].Error = "", ""
+				}
+			}
+			gaps = append(gaps, gap)
+		}
+	}
+
+	if fix && output != "" && !dryRunHTTP {
+		saveOrPrintManifest(manifest, output)
+	}
+
+	fixed := 0
+	for _, gap := range gaps {
+		if gap.Fixed {
+			fixed++
+		}
+	}
+	ok = fixed == len(gaps)
+	if reportFormat == "json" {
+		data, _ := json.MarshalIndent(gaps, "", "  ")
+		fmt.Println(string(data))
+		return ok
+	}
+
+	for _, gap := range gaps {
+		switch {
+		case gap.Fixed:
+			fmt.Printf("%-8s %s (%s) in %s: re-added\n", gap.Problem, gap.Path, gap.FileID, gap.VectorStoreID)
+		case gap.Error != "":
+			fmt.Printf("%-8s %s (%s) in %s: re-adding failed: %s\n", gap.Problem, gap.Path, gap.FileID, gap.VectorStoreID, gap.Error)
+		default:
+			fmt.Printf("%-8s %s (%s) in %s\n", gap.Problem, gap.Path, gap.FileID, gap.VectorStoreID)
+		}
+	}
+	fmt.Printf("%d files checked, %d not indexed, %d re-added\n", checked, len(gaps), fixed)
+	return ok
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// mergeManifests combines several manifests into one, keyed by path. When the
+// same path is tracked with different content or file IDs, the entry from the
+// most recently generated manifest wins and the conflict is reported. The
+// losing entry's upload is queued under pending_deletes, so cleanup removes
+// it instead of leaving it orphaned.
+func mergeManifests(paths []string) {
+	type source struct {
+		path      string
+		manifest  Manifest
+		generated time.Time
+	}
+
+	var manifests []source
+	for _, path := range paths {
+		path = strings.TrimSpace(path)
+		manifest, err := loadManifest(path)
+		if err != nil {
+			fmt.Printf("Error reading manifest %s: %v\n", path, err)
+			exit(1)
+		}
+		// Times carry their writer's UTC offset, so they're compared parsed; one
+		// that can't be parsed counts as oldest
+		generated, _ := time.Parse(time.RFC3339, manifest.LoggingInfo.GeneratedAt)
+		manifests = append(manifests, source{path, manifest, generated})
+	}
+
+	// Apply oldest first so newer manifests overwrite older entries
+	sort.SliceStable(manifests, func(i, j int) bool {
+		return manifests[i].generated.Before(manifests[j].generated)
+	})
+
+	merged := Manifest{ManifestID: manifestID}
+	files := make(map[string]FileInfo)
+	sources := make(map[string]string)
+	conflicts := 0
+	for _, src := range manifests {
+		manifest, source := src.manifest, src.path
+		for _, fileInfo := range manifest.Files {
+			if existing, ok := files[fileInfo.Path]; ok && (existing.SHA256 != fileInfo.SHA256 || existing.FileID != fileInfo.FileID) {
+				fmt.Printf("Conflict for %s: %s has %s (FileID %s), %s has %s (FileID %s); keeping %s\n",
+					fileInfo.Path, sources[fileInfo.Path], existing.SHA256, existing.FileID, source, fileInfo.SHA256, fileInfo.FileID, source)
+				conflicts++
+				if existing.FileID != "" && existing.FileID != fileInfo.FileID {
+					fmt.Printf("Queued FileID %s of %s for deletion\n", existing.FileID, fileInfo.Path)
+					merged.PendingDeletes = append(merged.PendingDeletes, existing)
+				}
+			}
+			files[fileInfo.Path] = fileInfo
+			sources[fileInfo.Path] = source
+		}
+		merged.PendingDeletes = append(merged.PendingDeletes, manifest.PendingDeletes...)
+		if manifestID == "" {
+			merged.ManifestID = manifest.ManifestID
+		}
+	}
+
+	for _, fileInfo := range files {
+		merged.Files = append(merged.Files, fileInfo)
+	}
+	// Entries are ordered as a scan orders them, looking their files up on disk
+	explicitPathSet = make(map[string]bool, len(merged.Files))
+	for _, fileInfo := range merged.Files {
+		explicitPathSet[fileInfo.Path] = true
+	}
+	sortFiles(merged.Files)
+
+	// An upload still used by a merged entry mustn't be deleted, and each is deleted once
+	live := make(map[string]bool, len(merged.Files))
+	for _, fileInfo := range merged.Files {
+		live[fileInfo.FileID] = true
+	}
+	var pending []FileInfo
+	for _, fileInfo := range merged.PendingDeletes {
+		if !live[fileInfo.FileID] {
+			live[fileInfo.FileID] = true
+			pending = append(pending, fileInfo)
+		}
+	}
+	merged.PendingDeletes = pending
+
+	merged.LoggingInfo = LogInfo{
+		GeneratedAt:  time.Now().Format(time.RFC3339),
+		OpenAIAPIKey: hideAPIKey(apiKey),
+		OutputFile:   output,
+		Tags:         tags,
+	}
+
+	fmt.Printf("Merged %d manifests: %d files, %d conflicts\n", len(manifests), len(merged.Files), conflicts)
+	saveOrPrintManifest(merged, output)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// runStats counts what a run did, for the -metrics-file export. Under -watch
+// the counts accumulate over every sync and are served on /metrics.
+type runStats struct {
+	filesUploaded atomic.Int64
+	bytesUploaded atomic.Int64
+	filesDeleted  atomic.Int64
+	failures      atomic.Int64
+
+	syncs            atomic.Int64
+	lastSyncDuration atomic.Int64 // nanoseconds
+	lastSyncAt       atomic.Int64 // Unix seconds
+}
+
+var stats runStats
+
+// daemonMetrics is the registry served on /metrics under -watch. Its
+// collectors read stats when scraped, so they cover every sync since the
+// daemon started.
+var daemonMetrics = prometheus.NewRegistry()
+
+func init() {
+	daemonMetrics.MustRegister(
+		statCounter("openai_sync_syncs_total", "Syncs performed since the daemon started.", &stats.syncs),
+		statCounter("openai_sync_files_uploaded_total", "Files uploaded since the daemon started.", &stats.filesUploaded),
+		statCounter("openai_sync_bytes_uploaded_total", "Bytes uploaded since the daemon started.", &stats.bytesUploaded),
+		statCounter("openai_sync_files_deleted_total", "Files deleted since the daemon started.", &stats.filesDeleted),
+		statCounter("openai_sync_failures_total", "Failed uploads and deletions since the daemon started.", &stats.failures),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "openai_sync_last_sync_duration_seconds",
+			Help: "Duration of the last sync.",
+		}, func() float64 { return time.Duration(stats.lastSyncDuration.Load()).Seconds() }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "openai_sync_last_sync_timestamp_seconds",
+			Help: "Unix time the last sync finished.",
+		}, func() float64 { return float64(stats.lastSyncAt.Load()) }),
+	)
+}
+
+func statCounter(name, help string, value *atomic.Int64) prometheus.Collector {
+	return prometheus.NewCounterFunc(prometheus.CounterOpts{Name: name, Help: help}, func() float64 {
+		return float64(value.Load())
+	})
+}
+
+func statGauge(name, help string, value float64) prometheus.Collector {
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: name, Help: help})
+	gauge.Set(value)
+	return gauge
+}
+
+// writeMetrics writes the run's metrics in the Prometheus text format to
+// path. The file is replaced by a rename, so node_exporter's textfile
+// collector never reads a partial one. After a failed run the last success
+// time is kept from the previous file, so alerts on its age still fire.
+func writeMetrics(path string, duration time.Duration, success bool) error {
+	lastSuccess, succeeded := float64(time.Now().Unix()), 1.0
+	if !success {
+		lastSuccess, succeeded = previousMetric(path, "openai_sync_last_success_timestamp_seconds"), 0
+	}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		statCounter("openai_sync_files_uploaded_total", "Files uploaded by the last run.", &stats.filesUploaded),
+		statCounter("openai_sync_bytes_uploaded_total", "Bytes uploaded by the last run.", &stats.bytesUploaded),
+		statCounter("openai_sync_files_deleted_total", "Files deleted by the last run.", &stats.filesDeleted),
+		statCounter("openai_sync_failures_total", "Failed uploads and deletions in the last run.", &stats.failures),
+		statGauge("openai_sync_duration_seconds", "Duration of the last run.", duration.Seconds()),
+		statGauge("openai_sync_success", "1 if the last run succeeded, 0 if it failed or was cut short.", succeeded),
+	)
+	if lastSuccess > 0 {
+		registry.MustRegister(statGauge("openai_sync_last_success_timestamp_seconds", "Unix time the last successful run finished.", lastSuccess))
+	}
+	return prometheus.WriteToTextfile(path, registry)
+}
+
+// previousMetric returns the value of the unlabelled metric name in the
+// metrics file at path, or 0 when the file or metric is missing.
+func previousMetric(path, name string) float64 {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		metric, value, ok := strings.Cut(scanner.Text(), " ")
+		if ok && metric == name {
+			v, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err == nil {
+				return v
+			}
+		}
+	}
+	return 0
+}
+
+// metricsHandler serves the watch daemon's metrics.
+func metricsHandler() http.Handler {
+	return promhttp.HandlerFor(daemonMetrics, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// apiHost is the machine looked up in a netrc file.
+const apiHost = "api.openai.com"
+
+// resolveAPIKey takes the API key from the password of the apiHost entry in
+// -netrc, or of its default entry, over OPENAI_API_KEY. Without -netrc, the
+// apiHost entry of ~/.netrc is only used when OPENAI_API_KEY isn't set, as a
+// key meant for another host mustn't be sent to the API unasked.
+func resolveAPIKey() error {
+	source := "env"
+	path, explicit := netrcPath, netrcPath != ""
+	if !explicit && apiKey == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, ".netrc")
+		}
+	}
+
+	if path != "" {
+		password, found, err := readNetrc(path, apiHost, explicit)
+		switch {
+		case errors.Is(err, fs.ErrNotExist) && !explicit:
+		case err != nil:
+			return fmt.Errorf("reading -netrc: %w", err)
+		case found:
+			apiKey = password
+			source = "netrc " + path
+		}
+	}
+	if apiKey == "" {
+		source = "unset"
+	}
+	slog.Info("resolved API key", "source", source)
+	return nil
+}
+
+// readNetrc returns the password of the machine entry for host, or with
+// useDefault of the default entry when there's none.
+func readNetrc(path, host string, useDefault bool) (password string, found bool, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", false, err
+	}
+	password, found = parseNetrc(string(data), host, useDefault)
+	return password, found, nil
+}
+
+// parseNetrc reads the netrc format: whitespace-separated tokens where
+// "machine <name>" or "default" starts an entry, followed by "login",
+// "password" and "account" pairs. Macro definitions run to the next blank
+// line, and lines starting with # are comments.
+func parseNetrc(data, host string, useDefault bool) (string, bool) {
+	var tokens []string
+	inMacro := false
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if inMacro {
+			inMacro = strings.TrimSpace(line) != ""
+			continue
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		for i, field := range fields {
+			if field == "macdef" {
+				tokens = append(tokens, fields[:i]...)
+				inMacro = true
+				break
+			}
+		}
+		if !inMacro {
+			tokens = append(tokens, fields...)
+		}
+	}
+
+	var machine, fallback, defaultPassword string
+	var hasDefault bool
+	for i := 0; i < len(tokens); i++ {
+		switch tokens[i] {
+		case "machine":
+			machine, fallback = "", ""
+			if i+1 < len(tokens) {
+				i++
+				machine = tokens[i]
+			}
+		case "default":
+			machine, fallback = "", "default"
+		case "login", "account", "password":
+			if i+1 >= len(tokens) {
+				break
+			}
+			i++
+			if tokens[i-1] != "password" {
+				continue
+			}
+			if machine == host {
+				return tokens[i], true
+			}
+			if useDefault && fallback == "default" && !hasDefault {
+				defaultPassword, hasDefault = tokens[i], true
+			}
+		}
+	}
+	return defaultPassword, hasDefault
+}
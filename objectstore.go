@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// S3ManifestStore keeps the manifest in an S3 object, or one in any
+// S3-compatible storage when AWS_ENDPOINT_URL is set. Credentials and the
+// region come from the standard AWS environment variables. Each save is a
+// single PUT, so readers see either the previous manifest or the new one.
+type S3ManifestStore struct {
+	Bucket string
+	Key    string
+}
+
+// GCSManifestStore keeps the manifest in a Google Cloud Storage object,
+// authenticating with the OAuth access token in GOOGLE_OAUTH_ACCESS_TOKEN,
+// e.g. from `gcloud auth print-access-token`. Each save is a single upload,
+// so readers see either the previous manifest or the new one.
+type GCSManifestStore struct {
+	Bucket string
+	Object string
+}
+
+// objectStoreClient sends manifest store requests. They bypass doRequest, so
+// manifests aren't subject to -max-response-bytes, throttling or simulated
+// latency meant for the OpenAI API.
+var objectStoreClient = &http.Client{Timeout: 5 * time.Minute}
+
+// objectStoreFor returns the store for an s3:// or gs:// manifest URI.
+func objectStoreFor(location string) (ManifestStore, bool) {
+	scheme, rest, ok := strings.Cut(location, "://")
+	if !ok || scheme != "s3" && scheme != "gs" {
+		return nil, false
+	}
+	bucket, key, _ := strings.Cut(rest, "/")
+	if scheme == "s3" {
+		return S3ManifestStore{Bucket: bucket, Key: key}, true
+	}
+	return GCSManifestStore{Bucket: bucket, Object: key}, true
+}
+
+func (s S3ManifestStore) Load() (Manifest, error) {
+	req, err := s.request("GET", nil)
+	if err != nil {
+		return Manifest{}, err
+	}
+	return loadObject(req, "s3://"+s.Bucket+"/"+s.Key)
+}
+
+func (s S3ManifestStore) Save(manifest Manifest) error {
+	req, err := s.request("PUT", marshalManifest(manifest))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return saveObject(req, "s3://"+s.Bucket+"/"+s.Key)
+}
+
+// request builds a signed request for the object. Without a custom
+// endpoint, virtual-hosted addressing is used, as AWS recommends.
+func (s S3ManifestStore) request(method string, body []byte) (*http.Request, error) {
+	accessKey, secretKey := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if accessKey == "" || secretKey == "" {
+		return nil, fmt.Errorf("s3://%s/%s: AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set", s.Bucket, s.Key)
+	}
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	target := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", s.Bucket, region, s3Escape(s.Key))
+	if endpoint := os.Getenv("AWS_ENDPOINT_URL"); endpoint != "" {
+		target = fmt.Sprintf("%s/%s/%s", strings.TrimSuffix(endpoint, "/"), s.Bucket, s3Escape(s.Key))
+	}
+	req, err := http.NewRequest(method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	signV4(req, body, accessKey, secretKey, os.Getenv("AWS_SESSION_TOKEN"), region, time.Now().UTC())
+	return req, nil
+}
+
+// signV4 signs an S3 request with AWS Signature Version 4.
+func signV4(req *http.Request, body []byte, accessKey, secretKey, sessionToken, region string, now time.Time) {
+	payloadHash := sha256.Sum256(body)
+	amzDate := now.Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+	signed := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", sessionToken)
+		signed = append(signed, "x-amz-security-token")
+	}
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	stringToSign := v4StringToSign(amzDate, scope, v4CanonicalRequest(req, signed, hex.EncodeToString(payloadHash[:])))
+
+	key := []byte("AWS4" + secretKey)
+	for _, part := range []string{date, region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, strings.Join(signed, ";"), signature))
+}
+
+// v4CanonicalRequest returns the canonical form of req that SigV4 signs,
+// covering the signed headers, which must be sorted lowercase names.
+func v4CanonicalRequest(req *http.Request, signed []string, payloadHash string) string {
+	var headers strings.Builder
+	for _, name := range signed {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.URL.Host
+		}
+		headers.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+
+	// Query parameters are sorted and every value is present, even if empty
+	query := req.URL.Query()
+	var params []string
+	for name, values := range query {
+		for _, value := range values {
+			params = append(params, awsEscape(name, false)+"="+awsEscape(value, false))
+		}
+	}
+	slices.Sort(params)
+
+	path := req.URL.Path
+	if path == "" {
+		path = "/"
+	}
+	return strings.Join([]string{
+		req.Method,
+		awsEscape(path, true),
+		strings.Join(params, "&"),
+		headers.String(),
+		strings.Join(signed, ";"),
+		payloadHash,
+	}, "\n")
+}
+
+func v4StringToSign(amzDate, scope, canonicalRequest string) string {
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	return "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Escape escapes an object key as SigV4 does, keeping the slashes, so the
+// request path is exactly the one signed.
+func s3Escape(key string) string {
+	return awsEscape(key, true)
+}
+
+// awsEscape percent-encodes every byte of s but the unreserved characters
+// A-Z, a-z, 0-9, '-', '.', '_' and '~', and slashes when keepSlash is set.
+func awsEscape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '.', c == '_', c == '~', c == '/' && keepSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func (s GCSManifestStore) Load() (Manifest, error) {
+	target := fmt.Sprintf("https://storage.googleapis.com/storage/v1/b/%s/o/%s?alt=media", url.PathEscape(s.Bucket), url.PathEscape(s.Object))
+	req, err := s.request("GET", target, nil)
+	if err != nil {
+		return Manifest{}, err
+	}
+	return loadObject(req, "gs://"+s.Bucket+"/"+s.Object)
+}
+
+func (s GCSManifestStore) Save(manifest Manifest) error {
+	query := url.Values{"uploadType": {"media"}, "name": {s.Object}}
+	target := fmt.Sprintf("https://storage.googleapis.com/upload/storage/v1/b/%s/o?%s", url.PathEscape(s.Bucket), query.Encode())
+	req, err := s.request("POST", target, marshalManifest(manifest))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return saveObject(req, "gs://"+s.Bucket+"/"+s.Object)
+}
+
+func (s GCSManifestStore) request(method, target string, body []byte) (*http.Request, error) {
+	token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN")
+	if token == "" {
+		return nil, fmt.Errorf("gs://%s/%s: GOOGLE_OAUTH_ACCESS_TOKEN must be set", s.Bucket, s.Object)
+	}
+	req, err := http.NewRequest(method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req, nil
+}
+
+// loadObject fetches a manifest object; a missing object is an empty manifest.
+func loadObject(req *http.Request, location string) (Manifest, error) {
+	var manifest Manifest
+	resp, err := objectStoreClient.Do(req)
+	if err != nil {
+		return manifest, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return manifest, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return manifest, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return manifest, fmt.Errorf("reading %s: %s: %s", location, resp.Status, string(data))
+	}
+	return unmarshalManifest(data)
+}
+
+func saveObject(req *http.Request, location string) error {
+	resp, err := objectStoreClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		return fmt.Errorf("writing %s: %s: %s", location, resp.Status, string(data))
+	}
+	return nil
+}
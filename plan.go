@@ -0,0 +1,22 @@
+package main
+
+// plannedManifest marks the entries a dry run would upload, for -plan-output:
+// each gets the status "pending" and a placeholder file ID derived from its
+// digest, so plans of the same content compare equal.
+func plannedManifest(manifest Manifest) Manifest {
+	files := make([]FileInfo, len(manifest.Files))
+	copy(files, manifest.Files)
+	for i, fileInfo := range files {
+		if fileInfo.FileID != "" {
+			continue
+		}
+		placeholder := fileInfo.SHA256
+		if len(placeholder) > 12 {
+			placeholder = placeholder[:12]
+		}
+		files[i].FileID = "planned-" + placeholder
+		files[i].Status = "pending"
+	}
+	manifest.Files = files
+	return manifest
+}
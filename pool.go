@@ -0,0 +1,173 @@
+package main
+
+import (
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// runPool calls fn for each job using -concurrency workers. Workers after the
+// first start with a random delay of up to -ramp-up so a large pool doesn't
+// hit the API all at once. With -adaptive-concurrency, the number of jobs in
+// flight is further limited by an AIMD controller, and with -throttle-on-error
+// by the error rate of recent requests. No new jobs start after
+// -run-timeout; callers treat jobs that never ran as left for the next run.
+func runPool(jobs []int, fn func(job int)) {
+	queue := make(chan int)
+	workers := concurrency
+	if workers < 1 {
+		workers = 1
+	}
+
+	if adaptiveConcurrency {
+		limiter = newAIMDLimiter(workers)
+		defer func() { limiter = nil }()
+	}
+	if throttleErrorRate > 0 {
+		throttle = newErrorThrottle(workers)
+		defer func() { throttle = nil }()
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		// Delays are drawn in worker order so a -rand-seed run repeats them exactly
+		var delay time.Duration
+		if w > 0 && rampUp > 0 {
+			delay = jitter(rampUp)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			time.Sleep(delay)
+			for job := range queue {
+				runJob(fn, job)
+			}
+		}()
+	}
+
+	// Once the run's deadline passes, jobs not yet handed to a worker are dropped
+dispatch:
+	for _, job := range jobs {
+		if runCtx.Err() != nil {
+			break
+		}
+		select {
+		case queue <- job:
+		case <-runCtx.Done():
+			break dispatch
+		}
+	}
+	close(queue)
+	wg.Wait()
+}
+
+// runJob calls fn once the pool's controllers allow another job to run.
+func runJob(fn func(job int), job int) {
+	if t := throttle; t != nil {
+		t.acquire()
+		defer t.release()
+	}
+	if l := limiter; l != nil {
+		l.acquire()
+		defer l.release()
+	}
+	fn(job)
+}
+
+// seededRand replaces the global source for jitter when -rand-seed is set,
+// making delays repeatable in tests.
+var (
+	seededRand   *rand.Rand
+	seededRandMu sync.Mutex
+)
+
+// jitter returns a random duration in [0, d).
+func jitter(d time.Duration) time.Duration {
+	if seededRand == nil {
+		return rand.N(d)
+	}
+	seededRandMu.Lock()
+	defer seededRandMu.Unlock()
+	return time.Duration(seededRand.Int64N(int64(d)))
+}
+
+// limiter is the adaptive controller of the running pool, if any; doRequest
+// reports rate limiting to it.
+var limiter *aimdLimiter
+
+// aimdLimiter adapts how many jobs may run at once: the limit grows by one
+// for every limit's worth of completed jobs and halves whenever the API
+// responds with 429 Too Many Requests.
+type aimdLimiter struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	limit  float64
+	max    int
+	active int
+	start  time.Time
+}
+
+func newAIMDLimiter(maxLimit int) *aimdLimiter {
+	l := &aimdLimiter{limit: 1, max: maxLimit, start: time.Now()}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+func (l *aimdLimiter) acquire() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.active >= int(l.limit) {
+		l.cond.Wait()
+	}
+	l.active++
+}
+
+func (l *aimdLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active--
+
+	previous := int(l.limit)
+	l.limit = min(l.limit+1/l.limit, float64(l.max))
+	if int(l.limit) != previous {
+		l.logLocked("increased")
+	}
+	l.cond.Broadcast()
+}
+
+func (l *aimdLimiter) backoff() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	previous := int(l.limit)
+	l.limit = max(l.limit/2, 1)
+	if int(l.limit) != previous {
+		l.logLocked("decreased after rate limiting")
+	}
+}
+
+func (l *aimdLimiter) logLocked(change string) {
+	elapsed := time.Since(l.start).Seconds()
+	slog.Debug("concurrency "+change,
+		"concurrency", int(l.limit),
+		"bytes_per_second", int64(float64(stats.bytesUploaded.Load())/elapsed),
+	)
+}
+
+// largeFileSlots holds a token for each upload of a file of at least
+// -large-file-size in flight, when -max-concurrent-large-files limits them.
+var largeFileSlots chan struct{}
+
+// acquireLargeFileSlot waits until a file of the given size may be uploaded
+// and returns the function that frees its slot. Smaller files never wait,
+// though while a worker waits it takes no other job.
+func acquireLargeFileSlot(size int64) (release func()) {
+	if largeFileSlots == nil || size < largeFileSize {
+		return func() {}
+	}
+	largeFileSlots <- struct{}{}
+	return func() { <-largeFileSlots }
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// preflight checks that a run can succeed without changing anything: the API
+// key is accepted, and each configured vector store exists. It reports how
+// many files the account holds and returns false on any problem.
+func preflight() bool {
+	ok := true
+
+	if apiKey == "" {
+		fmt.Println("FAIL  API key: OPENAI_API_KEY is not set")
+		return false
+	}
+	files, _, err := fetchRemoteFiles("")
+	if err != nil {
+		fmt.Printf("FAIL  API key: %v\n", err)
+		return false
+	}
+	var bytes int64
+	for _, file := range files {
+		bytes += file.Bytes
+	}
+	fmt.Printf("OK    API key accepted; the account has %d files using %d bytes\n", len(files), bytes)
+
+	stores := make(map[string]bool)
+	if vectorStoreID != "" {
+		stores[vectorStoreID] = true
+	}
+	for _, rule := range rules {
+		if rule.VectorStoreID != "" {
+			stores[rule.VectorStoreID] = true
+		}
+	}
+	if noVectorStore {
+		stores = nil
+	}
+	for id := range stores {
+		store, err := getVectorStore(id)
+		if err != nil {
+			fmt.Printf("FAIL  vector store %s: %v\n", id, err)
+			ok = false
+			continue
+		}
+		fmt.Printf("OK    vector store %s (%s): status %s, %d files, %d bytes\n", id, store.Name, store.Status, store.FileCounts.Total, store.UsageBytes)
+	}
+	return ok
+}
+
+type VectorStore struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	UsageBytes int64  `json:"usage_bytes"`
+	FileCounts struct {
+		Total int `json:"total"`
+	} `json:"file_counts"`
+}
+
+func getVectorStore(id string) (VectorStore, error) {
+	var store VectorStore
+	req, err := http.NewRequest("GET", "https://api.openai.com/v1/vector_stores/"+id, nil)
+	if err != nil {
+		return store, err
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+
+	resp, err := doRequest(req)
+	if err != nil {
+		return store, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := readBody(resp)
+	if err != nil {
+		return store, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return store, fmt.Errorf("vector store %s: %w", id, errNotFound)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return store, fmt.Errorf("Non-OK HTTP status: %s: %s", resp.Status, string(respBody))
+	}
+	err = json.Unmarshal(respBody, &store)
+	return store, err
+}
+
+func createVectorStore(name string) (VectorStore, error) {
+	var store VectorStore
+	valuesJSON, _ := json.Marshal(map[string]string{"name": name})
+	req, err := http.NewRequest("POST", "https://api.openai.com/v1/vector_stores", bytes.NewReader(valuesJSON))
+	if err != nil {
+		return store, err
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := doRequest(req)
+	if err != nil {
+		return store, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := readBody(resp)
+	if err != nil {
+		return store, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return store, fmt.Errorf("Non-OK HTTP status: %s: %s", resp.Status, string(respBody))
+	}
+	if err = json.Unmarshal(respBody, &store); err == nil && store.ID == "" {
+		err = fmt.Errorf("vector store created without an id")
+	}
+	return store, err
+}
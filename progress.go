@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// progressMinBytes is the smallest file whose hashing progress is shown on
+// the terminal; smaller files hash too quickly for it to help.
+const progressMinBytes = 64 << 20
+
+// progressReader reports the running total of bytes read to fn.
+type progressReader struct {
+	r     io.Reader
+	total int64
+	fn    func(total int64)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.total += int64(n)
+		p.fn(p.total)
+	}
+	return n, err
+}
+
+// hashingProgress returns a callback showing the hashing progress of a large
+// file on stderr, or nil when stderr is not a terminal or the file is small.
+func hashingProgress(path string, size int64) func(int64) {
+	if size < progressMinBytes {
+		return nil
+	}
+	if stat, err := os.Stderr.Stat(); err != nil || stat.Mode()&os.ModeCharDevice == 0 {
+		return nil
+	}
+
+	last := -1
+	return func(total int64) {
+		percent := int(total * 100 / size)
+		if percent == last {
+			return
+		}
+		last = percent
+		fmt.Fprintf(os.Stderr, "\rHashing %s: %d%%", path, percent)
+		if total >= size {
+			fmt.Fprintln(os.Stderr)
+		}
+	}
+}
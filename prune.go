@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// pruneManifest drops entries whose files no longer exist on disk, without
+// any API calls. Pruned files that were uploaded move to PendingDeletes so a
+// later cleanup run still deletes them remotely.
+func pruneManifest(manifest Manifest) {
+	var kept []FileInfo
+	pruned := 0
+	for _, fileInfo := range manifest.Files {
+		if _, err := os.Stat(fileInfo.Path); os.IsNotExist(err) {
+			fmt.Printf("Pruned %s\n", fileInfo.Path)
+			if fileInfo.FileID != "" {
+				manifest.PendingDeletes = append(manifest.PendingDeletes, fileInfo)
+			}
+			pruned++
+			continue
+		}
+		kept = append(kept, fileInfo)
+	}
+	manifest.Files = kept
+
+	fmt.Printf("Pruned %d of %d manifest entries\n", pruned, pruned+len(kept))
+	saveOrPrintManifest(manifest, output)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+)
+
+// Reconciliation compares tracked files with what exists remotely, once for
+// the account's files and once for each vector store the manifest uses.
+type Reconciliation struct {
+	Files        ReconciledSet            `json:"files"`
+	VectorStores map[string]ReconciledSet `json:"vector_stores,omitempty"`
+}
+
+type ReconciledSet struct {
+	Present   []TrackedFile `json:"present"`   // tracked and found remotely
+	Missing   []TrackedFile `json:"missing"`   // tracked but not found remotely
+	Untracked []string      `json:"untracked"` // remote file IDs the manifest doesn't track
+}
+
+type TrackedFile struct {
+	Path   string `json:"path"`
+	FileID string `json:"file_id"`
+}
+
+// compareRemote prints a reconciliation of the manifest against the account's
+// files and its vector stores. It only reads. Entries awaiting deletion count
+// as tracked; entries never uploaded are left out, having nothing to find.
+func compareRemote(manifest Manifest) error {
+	remote, _, err := fetchRemoteFiles("")
+	if err != nil {
+		return err
+	}
+	remoteIDs := make([]string, 0, len(remote))
+	for _, file := range remote {
+		remoteIDs = append(remoteIDs, file.ID)
+	}
+
+	var tracked []FileInfo
+	byStore := make(map[string][]FileInfo)
+	if store := storeFor(FileInfo{}); store != "" {
+		byStore[store] = nil
+	}
+	for _, fileInfo := range append(append([]FileInfo(nil), manifest.Files...), manifest.PendingDeletes...) {
+		if fileInfo.FileID == "" {
+			continue
+		}
+		tracked = append(tracked, fileInfo)
+		if store := storeFor(fileInfo); store != "" {
+			byStore[store] = append(byStore[store], fileInfo)
+		}
+	}
+
+	report := Reconciliation{Files: reconcile(tracked, remoteIDs)}
+	for store, files := range byStore {
+		storeFiles, err := listVectorStoreFiles(store)
+		if err != nil {
+			return err
+		}
+		if report.VectorStores == nil {
+			report.VectorStores = make(map[string]ReconciledSet)
+		}
+		ids := make([]string, 0, len(storeFiles))
+		for _, file := range storeFiles {
+			ids = append(ids, file.ID)
+		}
+		report.VectorStores[store] = reconcile(files, ids)
+	}
+
+	if reportFormat == "json" {
+		data, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(data))
+		return nil
+	}
+
+	printReconciledSet("files", report.Files)
+	stores := make([]string, 0, len(report.VectorStores))
+	for store := range report.VectorStores {
+		stores = append(stores, store)
+	}
+	sort.Strings(stores)
+	for _, store := range stores {
+		printReconciledSet("vector store "+store, report.VectorStores[store])
+	}
+	return nil
+}
+
+func reconcile(tracked []FileInfo, remoteIDs []string) ReconciledSet {
+	set := ReconciledSet{Present: []TrackedFile{}, Missing: []TrackedFile{}, Untracked: []string{}}
+	exists := make(map[string]bool, len(remoteIDs))
+	for _, id := range remoteIDs {
+		exists[id] = true
+	}
+	known := make(map[string]bool, len(tracked))
+	for _, fileInfo := range tracked {
+		known[fileInfo.FileID] = true
+		file := TrackedFile{Path: fileInfo.Path, FileID: fileInfo.FileID}
+		if exists[fileInfo.FileID] {
+			set.Present = append(set.Present, file)
+		} else {
+			set.Missing = append(set.Missing, file)
+		}
+	}
+	for _, id := range remoteIDs {
+		if !known[id] {
+			set.Untracked = append(set.Untracked, id)
+		}
+	}
+	sort.Slice(set.Present, func(i, j int) bool { return set.Present[i].Path < set.Present[j].Path })
+	sort.Slice(set.Missing, func(i, j int) bool { return set.Missing[i].Path < set.Missing[j].Path })
+	sort.Strings(set.Untracked)
+	return set
+}
+
+func printReconciledSet(name string, set ReconciledSet) {
+	fmt.Printf("%s: %d present, %d missing remotely, %d untracked\n", name, len(set.Present), len(set.Missing), len(set.Untracked))
+	for _, file := range set.Missing {
+		fmt.Printf("  missing    %s (%s)\n", file.Path, file.FileID)
+	}
+	for _, id := range set.Untracked {
+		fmt.Printf("  untracked  %s\n", id)
+	}
+}
+
+// listVectorStoreFiles pages through the files of a vector store. Their IDs
+// are those of the files they were created from.
+func listVectorStoreFiles(storeID string) ([]VectorStoreFile, error) {
+	var files []VectorStoreFile
+	after := ""
+	for {
+		query := url.Values{"limit": {"100"}, "order": {"asc"}}
+		if after != "" {
+			query.Set("after", after)
+		}
+		req, err := http.NewRequest("GET", fmt.Sprintf("https://api.openai.com/v1/vector_stores/%s/files?%s", storeID, query.Encode()), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+
+		resp, err := doRequest(req)
+		if err != nil {
+			return nil, err
+		}
+		body, err := readBody(resp)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("listing vector store %s files: %s: %s", storeID, resp.Status, string(body))
+		}
+
+		var page struct {
+			Data    []VectorStoreFile `json:"data"`
+			HasMore bool              `json:"has_more"`
+		}
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, fmt.Errorf("listing vector store %s files: %w", storeID, err)
+		}
+		files = append(files, page.Data...)
+		if !page.HasMore || len(page.Data) == 0 {
+			slog.Debug("listed vector store files", "vector_store_id", storeID, "count", len(files))
+			return files, nil
+		}
+		after = page.Data[len(page.Data)-1].ID
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type ReindexOutcome struct {
+	Path          string `json:"path"`
+	FileID        string `json:"file_id"`
+	VectorStoreID string `json:"vector_store_id"`
+	Status        string `json:"status,omitempty"` // vector store file status after adding it again
+	Error         string `json:"error,omitempty"`
+}
+
+// reindexAll removes every tracked file from its vector store and adds it
+// again, applying the current -chunk-size, -chunk-overlap and attributes
+// without uploading anything. The manifest records when the reindex began
+// and is saved after each file, so an interrupted reindex resumes with the
+// files not yet indexed since then. It returns false if any file failed.
+func reindexAll(manifest Manifest) bool {
+	if manifest.ReindexStartedAt == "" {
+		manifest.ReindexStartedAt = time.Now().UTC().Format(time.RFC3339)
+	} else {
+		fmt.Printf("Resuming reindex started at %s\n", manifest.ReindexStartedAt)
+	}
+
+	var pending []int
+	resumed := 0
+	for i, fileInfo := range manifest.Files {
+		if fileInfo.FileID == "" || storeFor(fileInfo) == "" {
+			continue
+		}
+		if fileInfo.IndexedAt >= manifest.ReindexStartedAt {
+			resumed++
+			continue
+		}
+		pending = append(pending, i)
+	}
+
+	if dryRun {
+		for _, i := range pending {
+			fmt.Printf("Would reindex %s in vector store %s\n", manifest.Files[i].Path, storeFor(manifest.Files[i]))
+		}
+		return true
+	}
+
+	save := func() {
+		if output != "" && !dryRunHTTP {
+			saveOrPrintManifest(manifest, output)
+		}
+	}
+	save()
+
+	var mu sync.Mutex
+	outcomes := []ReindexOutcome{}
+	runPool(pending, func(i int) {
+		mu.Lock()
+		fileInfo := manifest.Files[i]
+		mu.Unlock()
+
+		store := storeFor(fileInfo)
+		outcome := ReindexOutcome{Path: fileInfo.Path, FileID: fileInfo.FileID, VectorStoreID: store}
+		vsFile, err := reindexFile(store, fileInfo)
+		if err != nil {
+			outcome.Error = err.Error()
+			fmt.Printf("Error reindexing %s in vector store %s: %v\n", fileInfo.Path, store, err)
+		} else {
+			outcome.Status = vsFile.Status
+			fmt.Printf("Reindexed %s in vector store %s (%s)\n", fileInfo.Path, store, vsFile.Status)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		outcomes = append(outcomes, outcome)
+		if err == nil {
+			// Only finished indexing counts when resuming; a failed file is left to redo
+			manifest.Files[i].VectorStoreFileID = vsFile.ID
+			manifest.Files[i].VectorStoreStatus = vsFile.Status
+			manifest.Files[i].Status, manifest.Files[i].Error = "", ""
+			switch vsFile.Status {
+			case "completed":
+				manifest.Files[i].IndexedAt = time.Now().UTC().Format(time.RFC3339)
+			case "failed":
+				manifest.Files[i].Status, manifest.Files[i].Error = "failed", "indexing failed"
+			}
+			save()
+		}
+	})
+
+	failed := 0
+	for _, outcome := range outcomes {
+		if outcome.Error != "" || outcome.Status == "failed" {
+			failed++
+		}
+	}
+	left := len(pending) - len(outcomes)
+
+	// A complete reindex is forgotten, so the next one starts over
+	if failed == 0 && left == 0 {
+		manifest.ReindexStartedAt = ""
+		save()
+	}
+
+	if reportFormat == "json" {
+		data, _ := json.MarshalIndent(outcomes, "", "  ")
+		fmt.Println(string(data))
+	} else {
+		fmt.Printf("Reindexed %d files, %d failed, %d already done by an earlier run, %d left\n", len(outcomes)-failed, failed, resumed, left)
+	}
+	return failed == 0 && left == 0
+}
+
+// reindexFile removes a file from the vector store, if it's there, and adds
+// it again. With -wait, it returns once indexing has finished.
+func reindexFile(store string, fileInfo FileInfo) (VectorStoreFile, error) {
+	if err := removeFromVectorStore(store, fileInfo.FileID); err != nil && !errors.Is(err, errNotFound) {
+		return VectorStoreFile{}, err
+	}
+	vsFile, err := createVectorStoreFile(store, fileInfo.FileID, fileInfo.Attributes)
+	if err != nil || !waitForIndexing {
+		return vsFile, err
+	}
+
+	for vsFile.Status == "in_progress" {
+		select {
+		case <-runCtx.Done():
+			return vsFile, nil
+		case <-time.After(2 * time.Second):
+		}
+		if vsFile, err = getVectorStoreFile(store, fileInfo.FileID); err != nil {
+			return vsFile, err
+		}
+	}
+	return vsFile, nil
+}
+
+func getVectorStoreFile(storeID, fileID string) (VectorStoreFile, error) {
+	var vsFile VectorStoreFile
+	url := fmt.Sprintf("https://api.openai.com/v1/vector_stores/%s/files/%s", storeID, fileID)
+	req, _ := http.NewRequest("GET", url, nil)
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+
+	resp, err := doRequest(req)
+	if err != nil {
+		return vsFile, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := readBody(resp)
+	if err != nil {
+		return vsFile, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return vsFile, fmt.Errorf("Non-OK HTTP status: %s: %s", resp.Status, string(respBody))
+	}
+	err = json.Unmarshal(respBody, &vsFile)
+	return vsFile, err
+}
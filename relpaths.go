@@ -0,0 +1,76 @@
+package main
+
+import (
+	"log/slog"
+	"path/filepath"
+	"strings"
+)
+
+// resolvePaths turns the relative entry paths of a manifest written with
+// -relative-paths back into full paths under root.
+func resolvePaths(manifest *Manifest, root string) {
+	for _, files := range [][]FileInfo{manifest.Files, manifest.PendingDeletes} {
+		for i := range files {
+			if !filepath.IsAbs(files[i].Path) {
+				files[i].Path = filepath.Join(root, filepath.FromSlash(files[i].Path))
+			}
+		}
+	}
+}
+
+// relativizePaths returns a copy of the manifest whose entry paths under
+// root are relative to it, using forward slashes on every platform. Paths
+// outside root, such as -files elsewhere, stay as they are.
+func relativizePaths(manifest Manifest, root string) Manifest {
+	relativize := func(files []FileInfo) []FileInfo {
+		out := make([]FileInfo, len(files))
+		for i, fileInfo := range files {
+			if rel, ok := pathUnder(fileInfo.Path, root); ok {
+				fileInfo.Path = filepath.ToSlash(rel)
+			}
+			out[i] = fileInfo
+		}
+		return out
+	}
+	manifest.Files = relativize(manifest.Files)
+	manifest.PendingDeletes = relativize(manifest.PendingDeletes)
+	return manifest
+}
+
+// absolutePaths makes relative entry paths, as recorded from a relative
+// -folder, absolute against the working directory.
+func absolutePaths(manifest *Manifest) {
+	for _, files := range [][]FileInfo{manifest.Files, manifest.PendingDeletes} {
+		for i := range files {
+			if abs, err := filepath.Abs(files[i].Path); err == nil {
+				files[i].Path = abs
+			}
+		}
+	}
+}
+
+// rebasePaths moves entries recorded under the manifest's previous scan root
+// to root, so a relative manifest keeps matching its files after the folder
+// is moved or synced from another machine.
+func rebasePaths(manifest *Manifest, root string) {
+	previous := manifest.ScanRoot
+	if previous == "" || previous == root {
+		return
+	}
+	slog.Info("scan root moved, rebasing manifest paths", "from", previous, "to", root)
+	for _, files := range [][]FileInfo{manifest.Files, manifest.PendingDeletes} {
+		for i := range files {
+			if rel, ok := pathUnder(files[i].Path, previous); ok {
+				files[i].Path = filepath.Join(root, rel)
+			}
+		}
+	}
+}
+
+func pathUnder(path, root string) (string, bool) {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return rel, true
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"time"
+)
+
+// RemoteDiff compares a dry run's manifest with the files that actually exist
+// in the OpenAI account.
+type RemoteDiff struct {
+	RemoteOnly []RemoteFile `json:"remote_only"` // in the account but not tracked by the manifest
+	LocalOnly  []string     `json:"local_only"`  // tracked paths with no remote file, which the run would upload
+}
+
+type RemoteFile struct {
+	ID        string `json:"id"`
+	Filename  string `json:"filename"`
+	Purpose   string `json:"purpose"`
+	CreatedAt int64  `json:"created_at"`
+	Bytes     int64  `json:"bytes"`
+}
+
+// diffRemote lists every file in the account and compares it with the
+// manifest. Files awaiting deletion count as tracked, as cleanup handles them.
+func diffRemote(manifest Manifest) (*RemoteDiff, error) {
+	remote, err := listRemoteFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	tracked := make(map[string]bool)
+	for _, fileInfo := range manifest.PendingDeletes {
+		tracked[fileInfo.FileID] = true
+	}
+	diff := &RemoteDiff{RemoteOnly: []RemoteFile{}, LocalOnly: []string{}}
+	exists := make(map[string]bool)
+	for _, file := range remote {
+		exists[file.ID] = true
+	}
+	for _, fileInfo := range manifest.Files {
+		tracked[fileInfo.FileID] = true
+		if fileInfo.FileID == "" || !exists[fileInfo.FileID] {
+			diff.LocalOnly = append(diff.LocalOnly, fileInfo.Path)
+		}
+	}
+	for _, file := range remote {
+		if !tracked[file.ID] {
+			diff.RemoteOnly = append(diff.RemoteOnly, file)
+		}
+	}
+	sort.Strings(diff.LocalOnly)
+	return diff, nil
+}
+
+// remoteListCache is the -remote-cache file: the last remote list and the
+// validator the API returned with it.
+type remoteListCache struct {
+	FetchedAt time.Time    `json:"fetched_at"`
+	ETag      string       `json:"etag,omitempty"`
+	Files     []RemoteFile `json:"files"`
+}
+
+// listRemoteFiles returns the account's files, from the -remote-cache file
+// while it is younger than -remote-cache-ttl. Otherwise the list is fetched,
+// conditionally when the cache holds an ETag, so an unchanged list costs a
+// single 304 response.
+func listRemoteFiles() ([]RemoteFile, error) {
+	// Synthetic -dry-run-http responses must not end up in the cache
+	if remoteCachePath == "" || dryRunHTTP {
+		files, _, err := fetchRemoteFiles("")
+		return files, err
+	}
+
+	var cached remoteListCache
+	if data, err := os.ReadFile(remoteCachePath); err == nil {
+		if err := json.Unmarshal(data, &cached); err != nil {
+			slog.Warn("ignoring unreadable remote cache", "path", remoteCachePath, "error", err)
+			cached = remoteListCache{}
+		}
+	}
+	if !cached.FetchedAt.IsZero() && time.Since(cached.FetchedAt) < remoteCacheTTL {
+		slog.Debug("using cached remote file list", "fetched_at", cached.FetchedAt)
+		return cached.Files, nil
+	}
+
+	files, etag, err := fetchRemoteFiles(cached.ETag)
+	if errors.Is(err, errNotModified) {
+		slog.Debug("remote file list not modified", "etag", cached.ETag)
+		files, etag = cached.Files, cached.ETag
+	} else if err != nil {
+		return nil, err
+	}
+
+	data, _ := json.Marshal(remoteListCache{FetchedAt: time.Now(), ETag: etag, Files: files})
+	if err := os.WriteFile(remoteCachePath, data, 0644); err != nil {
+		slog.Warn("could not write remote cache", "path", remoteCachePath, "error", err)
+	}
+	return files, nil
+}
+
+var errNotModified = errors.New("not modified")
+
+// fetchRemoteFiles pages through GET /v1/files. It only reads, so it is safe
+// in dry-run mode. The returned ETag is only kept for single-page lists, as a
+// validator of the first page says nothing about the others.
+func fetchRemoteFiles(etag string) ([]RemoteFile, string, error) {
+	var files []RemoteFile
+	after := ""
+	for {
+		query := url.Values{"limit": {"10000"}, "order": {"asc"}}
+		if after != "" {
+			query.Set("after", after)
+		}
+		req, err := http.NewRequest("GET", "https://api.openai.com/v1/files?"+query.Encode(), nil)
+		if err != nil {
+			return nil, "", err
+		}
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+		if after == "" && etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+
+		resp, err := doRequest(req)
+		if err != nil {
+			return nil, "", err
+		}
+		body, err := readBody(resp)
+		resp.Body.Close()
+		if err != nil {
+			return nil, "", err
+		}
+		if resp.StatusCode == http.StatusNotModified {
+			return nil, "", errNotModified
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, "", fmt.Errorf("listing files: %s: %s", resp.Status, string(body))
+		}
+
+		var page struct {
+			Data    []RemoteFile `json:"data"`
+			HasMore bool         `json:"has_more"`
+		}
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, "", fmt.Errorf("listing files: %w", err)
+		}
+		files = append(files, page.Data...)
+		if after == "" && page.HasMore {
+			etag = ""
+		} else if after == "" {
+			etag = resp.Header.Get("ETag")
+		}
+		if !page.HasMore || len(page.Data) == 0 {
+			slog.Debug("listed remote files", "count", len(files))
+			return files, etag, nil
+		}
+		after = page.Data[len(page.Data)-1].ID
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"strings"
+)
+
+// Rule routes files matching Pattern to a purpose, vector store and set of
+// attributes. Empty fields fall back to the command-line defaults.
+type Rule struct {
+	Pattern       string                 `json:"pattern"`
+	Purpose       string                 `json:"purpose,omitempty"`
+	VectorStoreID string                 `json:"vector_store_id,omitempty"`
+	Attributes    map[string]interface{} `json:"attributes,omitempty"`
+}
+
+var rules []Rule
+
+// loadRules reads a -rules file: a JSON array of rules, evaluated in order.
+func loadRules(rulesPath string) ([]Rule, error) {
+	data, err := os.ReadFile(rulesPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var loaded []Rule
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return nil, fmt.Errorf("parsing -rules %s: %w", rulesPath, err)
+	}
+	for _, rule := range loaded {
+		if rule.Pattern == "" {
+			return nil, fmt.Errorf("-rules %s: every rule needs a pattern", rulesPath)
+		}
+		if _, err := path.Match(strings.TrimSuffix(rule.Pattern, "/**"), ""); err != nil {
+			return nil, fmt.Errorf("-rules %s: invalid pattern %q", rulesPath, rule.Pattern)
+		}
+	}
+	return loaded, nil
+}
+
+// ruleFor returns the first rule whose pattern matches the file's path inside
+// the scanned folder, or nil. A pattern ending in /** matches everything
+// below the directories matching the rest of it.
+func ruleFor(filePath string) *Rule {
+	name := fsPath(filePath)
+	for i, rule := range rules {
+		if dir, ok := strings.CutSuffix(rule.Pattern, "/**"); ok {
+			for parent := path.Dir(name); parent != "."; parent = path.Dir(parent) {
+				if matched, _ := path.Match(dir, parent); matched {
+					return &rules[i]
+				}
+			}
+			continue
+		}
+		if matched, _ := path.Match(rule.Pattern, name); matched {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// route applies the matching rule and -map-file name to a newly scanned file.
+// Sidecar attributes take precedence over the rule's.
+func route(fileInfo FileInfo, sidecarAttrs map[string]interface{}) FileInfo {
+	fileInfo.Filename = mappedFilename(fileInfo.Path)
+	attrs := defaultAttributes(fileInfo.Path)
+	if rule := ruleFor(fileInfo.Path); rule != nil {
+		fileInfo.Rule = rule.Pattern
+		fileInfo.Purpose = rule.Purpose
+		fileInfo.VectorStoreID = rule.VectorStoreID
+		attrs = mergeAttributes(attrs, rule.Attributes)
+	}
+	fileInfo.Attributes = mergeAttributes(attrs, sidecarAttrs)
+	return fileInfo
+}
+
+// mergeAttributes returns base with overrides copied over it, leaving both
+// unchanged. The result is nil when both are empty.
+func mergeAttributes(base, overrides map[string]interface{}) map[string]interface{} {
+	if len(overrides) == 0 {
+		return base
+	}
+	if len(base) == 0 {
+		return overrides
+	}
+	attrs := maps.Clone(base)
+	maps.Copy(attrs, overrides)
+	return attrs
+}
+
+// storeFor returns the vector store a file belongs in, or "" when vector
+// store updates are disabled.
+func storeFor(fileInfo FileInfo) string {
+	if noVectorStore {
+		return ""
+	}
+	if fileInfo.VectorStoreID != "" {
+		return fileInfo.VectorStoreID
+	}
+	return vectorStoreID
+}
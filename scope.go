@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// scopeRoot is the manifest path of the -scope subfolder while one is set.
+// Scanning, uploading and cleanup only touch entries under it, and the rest
+// of the manifest is carried over as it was.
+var scopeRoot string
+
+// scopeName returns -scope as a path inside the scanned filesystem, "." when
+// the whole folder is in scope.
+func scopeName() string {
+	if scope == "" {
+		return "."
+	}
+	return path.Clean(strings.Trim(filepath.ToSlash(scope), "/"))
+}
+
+// checkScope checks that -scope names a folder inside fsys, as a missing one
+// would look like every file in it was deleted.
+func checkScope(fsys fs.FS) error {
+	name := scopeName()
+	if !fs.ValidPath(name) {
+		return fmt.Errorf("-scope %s must be a path inside -folder", scope)
+	}
+	info, err := fs.Stat(fsys, name)
+	if err != nil {
+		return fmt.Errorf("-scope %s: %w", scope, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("-scope %s is not a folder", scope)
+	}
+	return nil
+}
+
+func inScope(path string) bool {
+	if scopeRoot == "" {
+		return true
+	}
+	_, ok := pathUnder(path, scopeRoot)
+	return ok
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"strings"
+)
+
+// isSidecar reports whether path is a metadata sidecar rather than content.
+func isSidecar(path string) bool {
+	return sidecarSuffix != "" && strings.HasSuffix(path, sidecarSuffix)
+}
+
+// loadSidecar reads the vector store attributes for path from its sidecar,
+// e.g. doc.md.meta.json for doc.md. A missing sidecar yields no attributes;
+// a malformed one is warned about and ignored.
+func loadSidecar(path string) map[string]interface{} {
+	if sidecarSuffix == "" {
+		return nil
+	}
+
+	data, err := readPath(path + sidecarSuffix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		slog.Warn("could not read sidecar", "path", path+sidecarSuffix, "error", err)
+		return nil
+	}
+
+	var attrs map[string]interface{}
+	if err := json.Unmarshal(data, &attrs); err != nil {
+		slog.Warn("ignoring malformed sidecar", "path", path+sidecarSuffix, "error", err)
+		return nil
+	}
+	for key, value := range attrs {
+		switch value.(type) {
+		case string, float64, bool:
+		default:
+			slog.Warn("ignoring sidecar attribute that is not a string, number or boolean", "path", path+sidecarSuffix, "attribute", key)
+			delete(attrs, key)
+		}
+	}
+	return attrs
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+)
+
+// UploadRejectedError reports a file the Files endpoint refused with a 400
+// or 415. Code is the API's error code from the body, if any.
+type UploadRejectedError struct {
+	Path       string
+	Status     string
+	StatusCode int
+	Code       string
+	Body       string
+}
+
+func newUploadRejectedError(path string, resp *http.Response, body []byte) *UploadRejectedError {
+	var apiError struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	json.Unmarshal(body, &apiError)
+	return &UploadRejectedError{Path: path, Status: resp.Status, StatusCode: resp.StatusCode, Code: apiError.Error.Code, Body: string(body)}
+}
+
+func (e *UploadRejectedError) Error() string {
+	return fmt.Sprintf("%s was rejected: %s: %s", e.Path, e.Status, e.Body)
+}
+
+// permanentRejectionCodes are the 400 error codes for files the API will
+// never accept. Others, such as rate or quota limits reported as a 400, may
+// pass on a later attempt.
+var permanentRejectionCodes = map[string]bool{
+	"unsupported_file":      true,
+	"unsupported_file_type": true,
+	"invalid_file_format":   true,
+	"file_too_large":        true,
+}
+
+// permanentFailure reports whether an upload error will recur on every
+// attempt, so the file belongs in the -skip-file: the file is too large, its
+// type unsupported (415), or the API rejected it with a permanent code.
+func permanentFailure(err error) bool {
+	var tooLarge *FileTooLargeError
+	if errors.As(err, &tooLarge) {
+		return true
+	}
+	var rejected *UploadRejectedError
+	return errors.As(err, &rejected) &&
+		(rejected.StatusCode == http.StatusUnsupportedMediaType || permanentRejectionCodes[rejected.Code])
+}
+
+// skipList is the -skip-file: files that failed permanently, with the
+// reason, which later runs leave alone instead of retrying.
+type skipList struct {
+	mu      sync.Mutex
+	path    string
+	entries map[string]string
+}
+
+var skips *skipList
+
+// loadSkipList reads the -skip-file, which need not exist yet. With clear,
+// it starts empty and is rewritten.
+func loadSkipList(path string, clear bool) (*skipList, error) {
+	s := &skipList{path: path, entries: make(map[string]string)}
+	if clear {
+		return s, s.saveLocked()
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var files []SkippedFile
+	if err := json.Unmarshal(data, &files); err != nil {
+		return nil, fmt.Errorf("parsing -skip-file %s: %w", path, err)
+	}
+	for _, file := range files {
+		s.entries[file.Path] = file.Reason
+	}
+	return s, nil
+}
+
+// reason returns why a file is listed, if it is.
+func (s *skipList) reason(path string) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reason, ok := s.entries[path]
+	return reason, ok
+}
+
+// add lists a file and saves the list at once, so an aborted run keeps it.
+func (s *skipList) add(path, reason string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[path] = reason
+	if err := s.saveLocked(); err != nil {
+		fmt.Printf("Error writing skip file %s: %v\n", s.path, err)
+	}
+}
+
+func (s *skipList) saveLocked() error {
+	files := []SkippedFile{}
+	for path, reason := range s.entries {
+		files = append(files, SkippedFile{Path: path, Reason: reason})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	data, _ := json.MarshalIndent(files, "", "  ")
+	return os.WriteFile(s.path, data, 0644)
+}
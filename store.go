@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+)
+
+// ManifestStore persists the manifest between runs. Programs embedding Sync
+// can implement it to keep manifests in object storage or a database.
+type ManifestStore interface {
+	// Load returns the stored manifest, or an empty one if none exists yet.
+	Load() (Manifest, error)
+	Save(manifest Manifest) error
+}
+
+// FileManifestStore keeps the manifest in a local file. With an empty Path
+// nothing is loaded and saving prints the manifest instead.
+type FileManifestStore struct {
+	Path string
+}
+
+func (s FileManifestStore) Load() (Manifest, error) {
+	if s.Path == "" {
+		return Manifest{}, nil
+	}
+	manifest, err := loadManifest(s.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Manifest{}, nil
+	}
+	return manifest, err
+}
+
+func (s FileManifestStore) Save(manifest Manifest) error {
+	data := marshalManifest(manifest)
+	if s.Path == "" {
+		if summaryOnly {
+			data, _ = json.MarshalIndent(summarize(manifest), "", "  ")
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+	if err := ioutil.WriteFile(s.Path, data, 0644); err != nil {
+		return err
+	}
+	return writeSummary(manifest, s.Path)
+}
+
+// StdoutManifestStore reads the manifest from Source, if set, but always
+// prints it when saving, so a run never overwrites the file.
+type StdoutManifestStore struct {
+	Source string
+}
+
+func (s StdoutManifestStore) Load() (Manifest, error) {
+	return locationStore(s.Source).Load()
+}
+
+func (s StdoutManifestStore) Save(manifest Manifest) error {
+	return FileManifestStore{}.Save(manifest)
+}
+
+// manifestStoreFor returns the -manifest-store backend for a manifest path.
+func manifestStoreFor(path string) ManifestStore {
+	if manifestStoreKind == "stdout" {
+		return StdoutManifestStore{Source: path}
+	}
+	return locationStore(path)
+}
+
+// locationStore returns the store for where a manifest lives: an s3:// or
+// gs:// object, or otherwise a local file.
+func locationStore(path string) ManifestStore {
+	if store, ok := objectStoreFor(path); ok {
+		return store
+	}
+	return FileManifestStore{Path: path}
+}
+
+// unmarshalManifest parses a manifest, resolving the entry paths recorded
+// relative to its scan root or without its stripped prefix to full paths.
+func unmarshalManifest(data []byte) (Manifest, error) {
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, err
+	}
+	if manifest.ScanRoot != "" {
+		resolvePaths(&manifest, manifest.ScanRoot)
+	} else if prefix := manifest.LoggingInfo.StripPrefix; prefix != "" {
+		resolvePaths(&manifest, prefix)
+	}
+	return manifest, nil
+}
+
+func marshalManifest(manifest Manifest) []byte {
+	var v interface{} = manifest
+	if manifestCompat != "" {
+		v = legacyManifest(manifest)
+	} else if manifest.ScanRoot != "" {
+		v = relativizePaths(manifest, manifest.ScanRoot)
+	} else if prefix := manifest.LoggingInfo.StripPrefix; prefix != "" {
+		v = relativizePaths(manifest, prefix)
+	}
+	var data []byte
+	if jsonIndent == "" {
+		data, _ = json.Marshal(v)
+	} else {
+		data, _ = json.MarshalIndent(v, "", jsonIndent)
+	}
+	return data
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+	"sync"
+)
+
+// quoteEscaper escapes a multipart header parameter, as mime/multipart does.
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// multipartBody streams a multipart form with the given fields followed by a
+// file part of type mimeType, or application/octet-stream when empty, read
+// from content, so uploads never hold a whole file in memory.
+// content is read through a -buffer-size buffer. The returned length is -1,
+// meaning a chunked request, when size is unknown (negative).
+func multipartBody(fields [][2]string, fileField, filename, mimeType string, content io.Reader, size int64) (body io.Reader, contentType string, length int64, err error) {
+	head := &bytes.Buffer{}
+	writer := multipart.NewWriter(head)
+	for _, field := range fields {
+		writer.WriteField(field[0], field[1])
+	}
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, quoteEscaper.Replace(fileField), quoteEscaper.Replace(filename)))
+	header.Set("Content-Type", mimeType)
+	if _, err := writer.CreatePart(header); err != nil {
+		return nil, "", 0, err
+	}
+	prefix := append([]byte(nil), head.Bytes()...)
+
+	// Closing the writer only adds the final boundary, which follows the content
+	head.Reset()
+	writer.Close()
+	suffix := head.Bytes()
+
+	length = -1
+	if size >= 0 {
+		length = int64(len(prefix)) + size + int64(len(suffix))
+	}
+	content = bufio.NewReaderSize(content, bufferSize)
+	return io.MultiReader(bytes.NewReader(prefix), content, bytes.NewReader(suffix)), writer.FormDataContentType(), length, nil
+}
+
+// hashingReader feeds everything read from r to h, so a file can be hashed
+// while it uploads instead of being read a second time.
+type hashingReader struct {
+	mu   sync.Mutex
+	r    io.Reader
+	h    io.Writer
+	done bool
+}
+
+func (hr *hashingReader) Read(p []byte) (int, error) {
+	hr.mu.Lock()
+	defer hr.mu.Unlock()
+	if hr.done {
+		return 0, io.EOF
+	}
+	n, err := hr.r.Read(p)
+	hr.h.Write(p[:n])
+	return n, err
+}
+
+// finish hashes whatever the upload didn't read, such as when a transport
+// discards the body, and stops any further reads so none can race it.
+func (hr *hashingReader) finish() error {
+	hr.mu.Lock()
+	defer hr.mu.Unlock()
+	hr.done = true
+	_, err := io.Copy(hr.h, hr.r)
+	return err
+}
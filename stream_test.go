@@ -0,0 +1,61 @@
+package main
+
+import (
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// TestUploadMemoryFlat checks that hashing and uploading a file allocates
+// about as much for a large file as for a small one.
+func TestUploadMemoryFlat(t *testing.T) {
+	serveAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		reader, err := r.MultipartReader()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for {
+			part, err := reader.NextPart()
+			if err != nil {
+				break
+			}
+			io.Copy(io.Discard, part)
+		}
+		w.Write([]byte(`{"id":"file-1"}`))
+	})
+
+	dir := t.TempDir()
+	allocated := func(size int64) uint64 {
+		path := filepath.Join(dir, "data.bin")
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Truncate(path, size); err != nil {
+			t.Fatal(err)
+		}
+		explicitPathSet = map[string]bool{path: true}
+		t.Cleanup(func() { explicitPathSet = nil })
+
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		if _, err := uploadFile(path, "data.bin", "", "assistants", "", sha256.New()); err != nil {
+			t.Fatal(err)
+		}
+		hashFile(path, "sha256")
+		runtime.ReadMemStats(&after)
+		return after.TotalAlloc - before.TotalAlloc
+	}
+
+	small := allocated(1 << 20)
+	large := allocated(64 << 20)
+	t.Logf("allocated %d bytes for 1 MiB, %d bytes for 64 MiB", small, large)
+	if large > small+4<<20 {
+		t.Errorf("uploading 64 MiB allocated %d bytes, against %d for 1 MiB", large, small)
+	}
+}
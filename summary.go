@@ -0,0 +1,51 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Summary is a compact view of a manifest for dashboards to poll without
+// parsing every entry. Its fields are kept stable.
+type Summary struct {
+	ManifestID   string `json:"manifest_id"`
+	CorpusDigest string `json:"corpus_digest"`
+	FileCount    int    `json:"file_count"`
+	TotalBytes   int64  `json:"total_bytes"`
+	LastRun      string `json:"last_run"`
+}
+
+// summaryPath returns where the summary of a manifest file is written:
+// manifest.json gets manifest.summary.json.
+func summaryPath(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".summary.json"
+}
+
+// summarize computes a manifest's summary. The corpus digest covers each
+// file's path and content digest, so it changes exactly when the corpus does.
+func summarize(manifest Manifest) Summary {
+	lines := make([]string, 0, len(manifest.Files))
+	summary := Summary{ManifestID: manifest.ManifestID, FileCount: len(manifest.Files), LastRun: manifest.LoggingInfo.GeneratedAt}
+	for _, fileInfo := range manifest.Files {
+		lines = append(lines, fileInfo.Path+"\x00"+fileInfo.hashAlgo()+":"+fileInfo.SHA256+"\n")
+		summary.TotalBytes += fileInfo.Bytes
+	}
+	sort.Strings(lines)
+
+	hash := sha256.New()
+	for _, line := range lines {
+		hash.Write([]byte(line))
+	}
+	summary.CorpusDigest = hex.EncodeToString(hash.Sum(nil))
+	return summary
+}
+
+func writeSummary(manifest Manifest, path string) error {
+	data, _ := json.MarshalIndent(summarize(manifest), "", "  ")
+	return ioutil.WriteFile(summaryPath(path), data, 0644)
+}
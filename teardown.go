@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+type DeletionReport struct {
+	Deleted     []string          `json:"deleted"`
+	AlreadyGone []string          `json:"already_gone"`
+	Failed      []DeletionFailure `json:"failed,omitempty"`
+}
+
+type DeletionFailure struct {
+	FileID string `json:"file_id"`
+	Path   string `json:"path"`
+	Error  string `json:"error"`
+}
+
+// deleteManifestFiles removes every file recorded in the manifest from OpenAI
+// and the vector store, undoing a prior sync without needing the local files.
+// Files that are already gone count as deleted, so the command is idempotent;
+// entries that failed stay in the manifest for a later retry.
+func deleteManifestFiles(manifest Manifest) {
+	var report DeletionReport
+	var remaining []FileInfo
+
+	if !dryRun {
+		count := 0
+		for _, fileInfo := range slices.Concat(manifest.Files, manifest.PendingDeletes) {
+			if fileInfo.FileID != "" {
+				count++
+			}
+		}
+		if count > 0 && !confirmAction(fmt.Sprintf("delete all %d files recorded in %s from OpenAI and their vector stores", count, output)) {
+			fmt.Println("Nothing deleted.")
+			exit(2)
+		}
+	}
+
+	for _, fileInfo := range slices.Concat(manifest.Files, manifest.PendingDeletes) {
+		if fileInfo.FileID == "" {
+			continue
+		}
+		if dryRun {
+			fmt.Printf("Would delete FileID: %s (%s)\n", fileInfo.FileID, fileInfo.Path)
+			remaining = append(remaining, fileInfo)
+			continue
+		}
+
+		gone := false
+		var err error
+		if store := storeFor(fileInfo); store != "" {
+			if err = removeFromVectorStore(store, fileInfo.FileID); errors.Is(err, errNotFound) {
+				err = nil
+			}
+		}
+		if err == nil {
+			if err = deleteFile(fileInfo.FileID); errors.Is(err, errNotFound) {
+				gone, err = true, nil
+			}
+		}
+
+		switch {
+		case err != nil:
+			report.Failed = append(report.Failed, DeletionFailure{FileID: fileInfo.FileID, Path: fileInfo.Path, Error: err.Error()})
+			remaining = append(remaining, fileInfo)
+		case gone:
+			report.AlreadyGone = append(report.AlreadyGone, fileInfo.FileID)
+		default:
+			report.Deleted = append(report.Deleted, fileInfo.FileID)
+			fmt.Printf("Deleted FileID: %s\n", fileInfo.FileID)
+		}
+	}
+
+	if dryRun {
+		return
+	}
+
+	data, _ := json.MarshalIndent(report, "", "  ")
+	fmt.Println(string(data))
+
+	if output != "" && !dryRunHTTP {
+		manifest.Files = remaining
+		manifest.PendingDeletes = nil
+		saveOrPrintManifest(manifest, output)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+)
+
+// throttle is the -throttle-on-error controller of the running pool, if any;
+// doRequest reports the outcome of every request to it.
+var throttle *errorThrottle
+
+// errorThrottle halves how many jobs may run at once whenever at least
+// -throttle-on-error of the last -throttle-window requests failed, whether by
+// a network error, a 5xx or a 429, and doubles it again after each run of
+// -throttle-recovery successes until it is back to -concurrency.
+type errorThrottle struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	limit    int
+	max      int
+	active   int
+	window   []bool // outcomes of recent requests, true for a failure
+	next     int
+	samples  int
+	failures int
+	streak   int // successes since the limit last changed
+}
+
+func newErrorThrottle(maxLimit int) *errorThrottle {
+	t := &errorThrottle{limit: maxLimit, max: maxLimit, window: make([]bool, throttleWindow)}
+	t.cond = sync.NewCond(&t.mu)
+	return t
+}
+
+func (t *errorThrottle) acquire() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for t.active >= t.limit {
+		t.cond.Wait()
+	}
+	t.active++
+}
+
+func (t *errorThrottle) release() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.active--
+	t.cond.Broadcast()
+}
+
+// record adds the outcome of a request to the window and adjusts the limit.
+func (t *errorThrottle) record(failed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.samples == len(t.window) && t.window[t.next] {
+		t.failures--
+	}
+	t.window[t.next] = failed
+	t.next = (t.next + 1) % len(t.window)
+	t.samples = min(t.samples+1, len(t.window))
+	if failed {
+		t.failures++
+		t.streak = 0
+	} else {
+		t.streak++
+	}
+
+	rate := float64(t.failures) / float64(t.samples)
+	if t.samples == len(t.window) && rate >= throttleErrorRate && t.limit > 1 {
+		t.limit = max(t.limit/2, 1)
+		slog.Warn("throttling after errors", "error_rate", rate, "requests", t.samples, "concurrency", t.limit)
+
+		// Only failures after this reduction count toward the next one
+		t.samples, t.failures, t.next = 0, 0, 0
+		return
+	}
+
+	if t.limit < t.max && t.streak >= throttleRecovery {
+		t.limit = min(t.limit*2, t.max)
+		t.streak = 0
+		if t.limit == t.max {
+			slog.Info("throttling disengaged", "concurrency", t.limit)
+		} else {
+			slog.Info("throttling eased", "concurrency", t.limit)
+		}
+		t.cond.Broadcast()
+	}
+}
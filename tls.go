@@ -0,0 +1,61 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// listFlag collects the values of a flag that may be repeated.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// loadTLSConfig returns a TLS configuration that also trusts the -ca-cert
+// bundles, on top of the system pool, and presents the -client-cert key pair
+// when both halves are given. It returns nil when neither is set.
+func loadTLSConfig() (*tls.Config, error) {
+	if (clientCert == "") != (clientKey == "") {
+		slog.Warn("-client-cert and -client-key must be used together, ignoring them")
+	}
+	useClientCert := clientCert != "" && clientKey != ""
+	if len(caCerts) == 0 && !useClientCert {
+		return nil, nil
+	}
+
+	config := &tls.Config{}
+	if useClientCert {
+		pair, err := tls.LoadX509KeyPair(clientCert, clientKey)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{pair}
+	}
+	if len(caCerts) > 0 {
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		for _, path := range caCerts {
+			pem, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("reading -ca-cert: %w", err)
+			}
+			if !roots.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("-ca-cert %s contains no PEM certificates", path)
+			}
+		}
+		config.RootCAs = roots
+	}
+	return config, nil
+}
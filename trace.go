@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// withTrace returns req instrumented to log, at debug level, how long each
+// phase of the request took: DNS lookup, connecting, the TLS handshake, and
+// waiting for the first response byte after the request was written. Only
+// the method, host and path are logged, never headers, query strings or
+// bodies.
+func withTrace(req *http.Request) *http.Request {
+	var (
+		mu                                    sync.Mutex
+		start                                 = time.Now()
+		dnsStart, connectStart, tlsStart      time.Time
+		dns, connect, handshake, wroteRequest time.Duration
+		reused                                bool
+	)
+	since := func(t time.Time) time.Duration {
+		if t.IsZero() {
+			return 0
+		}
+		return time.Since(t)
+	}
+
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			dnsStart = time.Now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			dns = since(dnsStart)
+		},
+		ConnectStart: func(string, string) {
+			mu.Lock()
+			defer mu.Unlock()
+			connectStart = time.Now()
+		},
+		ConnectDone: func(string, string, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			connect = since(connectStart)
+		},
+		TLSHandshakeStart: func() {
+			mu.Lock()
+			defer mu.Unlock()
+			tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			handshake = since(tlsStart)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			reused = info.Reused
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			wroteRequest = time.Since(start)
+		},
+		GotFirstResponseByte: func() {
+			mu.Lock()
+			defer mu.Unlock()
+			slog.Debug("request trace",
+				"method", req.Method,
+				"host", req.URL.Host,
+				"path", req.URL.Path,
+				"reused_conn", reused,
+				"dns", dns,
+				"connect", connect,
+				"tls", handshake,
+				"write", wroteRequest,
+				"server", time.Since(start)-wroteRequest,
+				"first_byte", time.Since(start),
+			)
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
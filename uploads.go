@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+)
+
+// UploadState tracks an in-progress Uploads API upload so an interrupted run
+// can add only the missing parts instead of starting over.
+type UploadState struct {
+	UploadID string   `json:"upload_id"`
+	SHA256   string   `json:"sha256"` // digest of the file when the upload started, using the entry's HashAlgo
+	PartSize int64    `json:"part_size"`
+	PartIDs  []string `json:"part_ids,omitempty"`
+}
+
+// uploadLargeFile uploads a file in parts through the Uploads API, resuming
+// from fileInfo.Upload when it belongs to the same content. progress is called
+// after the upload is created and after each part so the caller can persist it.
+func uploadLargeFile(fileInfo FileInfo, purpose string, progress func(*UploadState)) (string, error) {
+	state := fileInfo.Upload
+	if state != nil && !hashMatches(fileInfo.Path, fileInfo.hashAlgo(), state.SHA256) {
+		fmt.Printf("%s changed since upload %s started, restarting\n", fileInfo.Path, state.UploadID)
+		cancelUpload(state.UploadID)
+		state = nil
+	}
+
+	resumed := state != nil
+	for {
+		if state == nil {
+			var err error
+			if state, err = createUpload(fileInfo, purpose); err != nil {
+				return "", err
+			}
+			progress(state)
+		} else {
+			fmt.Printf("Resuming upload %s of %s at part %d\n", state.UploadID, fileInfo.Path, len(state.PartIDs)+1)
+		}
+
+		err := uploadParts(fileInfo.Path, state, progress)
+		if err == nil {
+			return completeUpload(state)
+		}
+		if !resumed {
+			return "", err
+		}
+
+		// The resumed upload may have expired server-side; start a fresh one once
+		fmt.Printf("Could not resume upload %s (%v), restarting\n", state.UploadID, err)
+		state = nil
+		resumed = false
+	}
+}
+
+func createUpload(fileInfo FileInfo, purpose string) (*UploadState, error) {
+	size, err := contentSize(fileInfo.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	mimeType := mime.TypeByExtension(filepath.Ext(fileInfo.Path))
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+
+	values := map[string]interface{}{
+		"filename":  fileInfo.uploadName(),
+		"purpose":   purpose,
+		"bytes":     size,
+		"mime_type": mimeType,
+	}
+	if expiresAfter != nil {
+		values["expires_after"] = expiresAfter
+	}
+	valuesJSON, _ := json.Marshal(values)
+
+	req, _ := http.NewRequest("POST", "https://api.openai.com/v1/uploads", bytes.NewReader(valuesJSON))
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	result, err := doUploadRequest(req)
+	if err != nil {
+		return nil, fmt.Errorf("creating upload of %s: %w", fileInfo.Path, err)
+	}
+	uploadID, _ := result["id"].(string)
+	if uploadID == "" {
+		return nil, fmt.Errorf("creating upload of %s returned no upload ID", fileInfo.Path)
+	}
+	return &UploadState{UploadID: uploadID, SHA256: fileInfo.SHA256, PartSize: partSize}, nil
+}
+
+func uploadParts(filePath string, state *UploadState, progress func(*UploadState)) error {
+	// Parts carry the same content that was hashed, normalized under -normalize-eol
+	size, err := contentSize(filePath)
+	if err != nil {
+		return err
+	}
+	file, err := openContent(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// Skip the parts already uploaded, seeking when the content allows it
+	offset := int64(len(state.PartIDs)) * state.PartSize
+	if seeker, ok := file.(io.Seeker); ok {
+		_, err = seeker.Seek(offset, io.SeekStart)
+	} else {
+		_, err = io.CopyN(io.Discard, file, offset)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Each part is streamed from the file rather than buffered in memory
+	for {
+		n := min(state.PartSize, size-offset)
+		if n <= 0 {
+			return nil
+		}
+		body, contentType, length, err := multipartBody(nil, "data", filepath.Base(filePath), "", io.LimitReader(file, n), n)
+		if err != nil {
+			return err
+		}
+
+		url := fmt.Sprintf("https://api.openai.com/v1/uploads/%s/parts", state.UploadID)
+		req, _ := http.NewRequest("POST", url, body)
+		req.ContentLength = length
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+		req.Header.Set("Content-Type", contentType)
+
+		resp, err := doRequest(req)
+		if err != nil {
+			return err
+		}
+		respBody, err := readBody(resp)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("adding upload part: %s: %s", resp.Status, string(respBody))
+		}
+
+		var result map[string]interface{}
+		json.Unmarshal(respBody, &result)
+		partID, _ := result["id"].(string)
+		if partID == "" {
+			return fmt.Errorf("adding upload part returned no part ID: %s", string(respBody))
+		}
+		state.PartIDs = append(state.PartIDs, partID)
+		progress(state)
+		offset += n
+	}
+}
+
+func completeUpload(state *UploadState) (string, error) {
+	url := fmt.Sprintf("https://api.openai.com/v1/uploads/%s/complete", state.UploadID)
+	values := map[string]interface{}{"part_ids": state.PartIDs}
+	valuesJSON, _ := json.Marshal(values)
+
+	req, _ := http.NewRequest("POST", url, bytes.NewReader(valuesJSON))
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	result, err := doUploadRequest(req)
+	if err != nil {
+		return "", fmt.Errorf("completing upload %s: %w", state.UploadID, err)
+	}
+	file, _ := result["file"].(map[string]interface{})
+	fileID, _ := file["id"].(string)
+	if fileID == "" {
+		return "", fmt.Errorf("upload %s completed without a file", state.UploadID)
+	}
+	return fileID, nil
+}
+
+func cancelUpload(uploadID string) {
+	url := fmt.Sprintf("https://api.openai.com/v1/uploads/%s/cancel", uploadID)
+	req, _ := http.NewRequest("POST", url, nil)
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+
+	resp, err := doRequest(req)
+	if err != nil {
+		fmt.Printf("Error cancelling upload %s: %v\n", uploadID, err)
+		return
+	}
+	resp.Body.Close()
+}
+
+func doUploadRequest(req *http.Request) (map[string]interface{}, error) {
+	resp, err := doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := readBody(resp)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Non-OK HTTP status: %s: %s", resp.Status, string(respBody))
+	}
+
+	var result map[string]interface{}
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return nil, fmt.Errorf("response is not a JSON object: %w", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+const unsupportedImageReason = "unsupported image type for vision"
+
+// imageExtensions maps the image types the vision purpose accepts to their
+// file extensions, the first being the one added to names without any.
+var imageExtensions = map[string][]string{
+	"image/png":  {".png"},
+	"image/jpeg": {".jpg", ".jpeg"},
+	"image/webp": {".webp"},
+	"image/gif":  {".gif"},
+}
+
+func isVisionFile(fileInfo FileInfo) bool {
+	purpose := fileInfo.Purpose
+	if purpose == "" {
+		purpose = purposeFor(fileInfo.Path)
+	}
+	return purpose == "vision"
+}
+
+// sniffContentType detects a file's type from its first bytes, as the
+// extension may be missing or wrong.
+func sniffContentType(path string) (string, error) {
+	file, err := openPath(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	return http.DetectContentType(head[:n]), nil
+}
+
+// imageName returns the upload name for an image of the given type, adding
+// the type's extension unless the name already has a matching one, since
+// the API also goes by the extension.
+func imageName(name, mimeType string) string {
+	extensions := imageExtensions[mimeType]
+	if len(extensions) == 0 {
+		return name
+	}
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, e := range extensions {
+		if ext == e {
+			return name
+		}
+	}
+	return name + extensions[0]
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+var (
+	pngHeader  = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	jpegHeader = []byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00")
+)
+
+func TestVisionUploadContentType(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  []byte
+		wantType string
+		wantName string
+	}{
+		{"scan", pngHeader, "image/png", "scan.png"},
+		{"diagram.PNG", pngHeader, "image/png", "diagram.PNG"},
+		{"photo.jpeg", jpegHeader, "image/jpeg", "photo.jpeg"},
+		{"photo.png", jpegHeader, "image/jpeg", "photo.png.jpg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serveAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				if err := r.ParseMultipartForm(1 << 20); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				_, header, err := r.FormFile("file")
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				if got := header.Header.Get("Content-Type"); got != tt.wantType {
+					t.Errorf("Content-Type = %q, want %q", got, tt.wantType)
+				}
+				if header.Filename != tt.wantName {
+					t.Errorf("filename = %q, want %q", header.Filename, tt.wantName)
+				}
+				w.Write([]byte(`{"id":"file-img"}`))
+			})
+
+			if _, err := UploadContent(context.Background(), tt.name, bytes.NewReader(tt.content), "vision"); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestVisionSkipsNonImages(t *testing.T) {
+	saved := purpose
+	purpose = "vision"
+	t.Cleanup(func() { purpose = saved })
+
+	fsys := fstest.MapFS{
+		"photo.jpg":   {Data: jpegHeader},
+		"scan":        {Data: pngHeader},
+		"notes.txt":   {Data: []byte("plain text")},
+		"fake.png":    {Data: []byte("<html>not an image</html>")},
+		"nested/a.md": {Data: []byte("# heading")},
+	}
+	manifest, skipped := scanFolder(fsys, "/corpus", Manifest{})
+
+	var tracked []string
+	for _, fileInfo := range manifest.Files {
+		tracked = append(tracked, fileInfo.Path)
+	}
+	slices.Sort(tracked)
+	if want := "/corpus/photo.jpg /corpus/scan"; strings.Join(tracked, " ") != want {
+		t.Errorf("tracked %v, want %s", tracked, want)
+	}
+
+	reasons := make(map[string]string)
+	for _, skip := range skipped {
+		reasons[skip.Path] = skip.Reason
+	}
+	for _, path := range []string{"/corpus/notes.txt", "/corpus/fake.png", "/corpus/nested/a.md"} {
+		if !strings.HasPrefix(reasons[path], unsupportedImageReason) {
+			t.Errorf("%s skipped with %q, want %q", path, reasons[path], unsupportedImageReason)
+		}
+	}
+}
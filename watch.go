@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// changedPaths limits a -watch sync to the files and folders that changed,
+// as manifest paths: only they are scanned again, and only entries under
+// them can be dropped for cleanup. nil scans everything.
+var changedPaths []string
+
+// watched reports whether path is, or is inside, one of changedPaths.
+func watched(path string) bool {
+	if changedPaths == nil {
+		return true
+	}
+	for _, changed := range changedPaths {
+		if _, ok := pathUnder(path, changed); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// watch keeps syncing until the run's context ends. Changes the filesystem
+// reports under the folder and to -files are collected until none have
+// arrived for a whole -watch-interval, so a burst of writes leads to a
+// single sync, which only scans the changed paths again.
+func watch(fsys fs.FS, root string, manifest Manifest) {
+	// Unchanged files are recognised from the hash cache instead of being rehashed every sync
+	if cache == nil {
+		cache = &hashCache{entries: make(map[string]string), seen: make(map[string]string)}
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		fmt.Printf("Error: watching %s: %v\n", root, err)
+		exit(1)
+	}
+	defer watcher.Close()
+	if root != "" {
+		addWatches(watcher, root)
+	}
+	// Editors often replace a file rather than write it, so -files are watched through their folder
+	for _, path := range explicitPaths {
+		if err := watcher.Add(filepath.Dir(path)); err != nil {
+			slog.Warn("could not watch -files entry", "path", path, "error", err)
+		}
+	}
+
+	manifest = watchSync(fsys, root, manifest)
+	changes := make(map[string]bool)
+	rescan := false
+	var settle <-chan time.Time
+	for {
+		select {
+		case <-runCtx.Done():
+			return
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+			path := filepath.Clean(event.Name)
+			_, inFolder := pathUnder(path, root)
+			if root == "" || !inFolder {
+				if !explicitPathSet[path] {
+					continue
+				}
+			} else if event.Has(fsnotify.Create) && !ignoredDirs[filepath.Base(path)] {
+				if info, err := os.Stat(path); err == nil && info.IsDir() {
+					addWatches(watcher, path)
+				}
+			}
+
+			changes[path] = true
+			if isSidecar(path) {
+				changes[strings.TrimSuffix(path, sidecarSuffix)] = true
+			}
+			settle = time.After(watchInterval)
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			// Events may have been lost, so the next sync scans everything
+			slog.Warn("watching failed, rescanning", "folder", root, "error", err)
+			rescan = true
+			settle = time.After(watchInterval)
+
+		case <-settle:
+			if !rescan {
+				changedPaths = slices.Sorted(maps.Keys(changes))
+			}
+			slog.Info("change detected, syncing", "folder", root, "paths", len(changes), "rescan", rescan)
+			manifest = watchSync(fsys, root, manifest)
+			changedPaths = nil
+			clear(changes)
+			rescan, settle = false, nil
+		}
+	}
+}
+
+// addWatches watches dir and the folders below it, except ignored ones, as
+// a watch doesn't extend to subfolders.
+func addWatches(watcher *fsnotify.Watcher, dir string) {
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		if path != dir && ignoredDirs[d.Name()] {
+			return fs.SkipDir
+		}
+		if err := watcher.Add(path); err != nil {
+			slog.Warn("could not watch folder", "path", path, "error", err)
+		}
+		return nil
+	})
+}
+
+// watchSync runs one sync and saves the manifest. Errors are reported and
+// recorded as a failed sync, and the previous manifest kept so the next change
+// retries, unless the sync stopped partway, when what it did is kept instead.
+func watchSync(fsys fs.FS, root string, manifest Manifest) Manifest {
+	start := time.Now()
+	updated, err := Sync(fsys, root, manifest)
+	stats.syncs.Add(1)
+	stats.lastSyncDuration.Store(int64(time.Since(start)))
+	stats.lastSyncAt.Store(time.Now().Unix())
+	health.recordSync(err)
+	if cache.path != "" {
+		cache.save()
+	}
+	var aborted *SyncAbortedError
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		if !errors.As(err, &aborted) {
+			return manifest
+		}
+	}
+	saveOrPrintManifest(updated, output)
+	return updated
+}